@@ -0,0 +1,201 @@
+package dokku_common
+
+import (
+	"encoding/json"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditAction is the kind of decision an AuditEvent records.
+type AuditAction string
+
+const (
+	// AuditAuthentication is the verification of a token.
+	AuditAuthentication AuditAction = "authentication"
+	// AuditAuthorization is the check of the tenant and roles of a principal.
+	AuditAuthorization AuditAction = "authorization"
+)
+
+// AuditDecision is the outcome of an audited decision.
+type AuditDecision string
+
+const (
+	AuditAllow AuditDecision = "allow"
+	AuditDeny  AuditDecision = "deny"
+)
+
+// RequestIDHeader is the header the request ID of audit events is read from.
+var RequestIDHeader = "X-Request-ID"
+
+// DefaultAuditSink, when set, receives the events of the token middlewares, of
+// RequireTenantRole and of the Authenticators configured without WithAuditSink.
+var DefaultAuditSink AuditSink
+
+// AuditEvent records an authentication or authorization decision. Route is the method and
+// path of an HTTP request, or the full method of a gRPC call. Latency is the time taken to
+// reach the decision.
+type AuditEvent struct {
+	Time       time.Time     `json:"time"`
+	Action     AuditAction   `json:"action"`
+	Decision   AuditDecision `json:"decision"`
+	Reason     string        `json:"reason,omitempty"`
+	Subject    string        `json:"subject,omitempty"`
+	TokenID    string        `json:"jti,omitempty"`
+	AuthMethod AuthMethod    `json:"auth_method,omitempty"`
+	Tenant     string        `json:"tenant,omitempty"`
+	Role       string        `json:"role,omitempty"`
+	RequestID  string        `json:"request_id,omitempty"`
+	Route      string        `json:"route,omitempty"`
+	RemoteAddr string        `json:"remote_addr,omitempty"`
+	Latency    time.Duration `json:"latency_ns"`
+}
+
+// SetClaim fills the subject and token ID of the event from the claim.
+func (e *AuditEvent) SetClaim(claim *security.GoClaim) {
+	if claim == nil {
+		return
+	}
+	e.Subject = claim.Subscriber
+	e.TokenID = claim.Tokenid
+	e.AuthMethod = AuthMethodJWT
+}
+
+// SetPrincipal fills the subject, and token ID for token principals, of the event.
+func (e *AuditEvent) SetPrincipal(principal Principal) {
+	if principal == nil {
+		return
+	}
+	if cp, ok := principal.(*ClaimPrincipal); ok {
+		e.SetClaim(cp.Claim)
+	}
+	e.Subject = principal.Subject()
+	e.AuthMethod = principal.AuthMethod()
+}
+
+// AuditSink receives the audit events. Audit must be safe for concurrent use and should not
+// block the request for long.
+type AuditSink interface {
+	Audit(event *AuditEvent)
+}
+
+// AuditSinks sends every event to each of its sinks.
+type AuditSinks []AuditSink
+
+// Audit implements AuditSink.
+func (as AuditSinks) Audit(event *AuditEvent) {
+	for _, sink := range as {
+		sink.Audit(event)
+	}
+}
+
+// JSONLinesAuditSink writes each event as a line of JSON.
+type JSONLinesAuditSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewJSONLinesAuditSink creates a sink writing to w.
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{writer: w}
+}
+
+// OpenJSONLinesAuditFile creates a sink appending to the file at path, created if needed
+// with permissions restricted to its owner. Close the sink to close the file.
+func OpenJSONLinesAuditFile(path string) (*JSONLinesAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLinesAuditSink(file), nil
+}
+
+// Audit implements AuditSink. Write failures are logged, the request goes on.
+func (s *JSONLinesAuditSink) Audit(event *AuditEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("audit event can not be encoded: %s", err.Error())
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		logrus.Errorf("audit event can not be written: %s", err.Error())
+	}
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (s *JSONLinesAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if closer, ok := s.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// LogrusAuditSink logs each event with its members as fields, at info level for allowed
+// requests and warning level for denied ones. Logger is the standard logger if nil.
+type LogrusAuditSink struct {
+	Logger *logrus.Logger
+}
+
+// Audit implements AuditSink.
+func (s *LogrusAuditSink) Audit(event *AuditEvent) {
+	logger := s.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	entry := logger.WithFields(logrus.Fields{
+		"action":      event.Action,
+		"decision":    event.Decision,
+		"reason":      event.Reason,
+		"subject":     event.Subject,
+		"jti":         event.TokenID,
+		"auth_method": event.AuthMethod,
+		"tenant":      event.Tenant,
+		"role":        event.Role,
+		"request_id":  event.RequestID,
+		"route":       event.Route,
+		"remote_addr": event.RemoteAddr,
+		"latency":     event.Latency,
+	})
+	if event.Decision == AuditDeny {
+		entry.Warn("audit")
+	} else {
+		entry.Info("audit")
+	}
+}
+
+// auditRequest completes the event with the request details and sends it to the sink.
+func auditRequest(sink AuditSink, r *http.Request, start time.Time, event *AuditEvent) {
+	if sink == nil {
+		return
+	}
+	now := time.Now()
+	event.Time = now
+	event.Latency = now.Sub(start)
+	event.RequestID = r.Header.Get(RequestIDHeader)
+	event.Route = r.Method + " " + r.URL.Path
+	event.RemoteAddr = r.RemoteAddr
+	sink.Audit(event)
+}
+
+// auditToken records the verification of the token of the request, claim is nil when err
+// tells why it was refused.
+func auditToken(sink AuditSink, r *http.Request, start time.Time, claim *security.GoClaim, err error) {
+	if sink == nil {
+		return
+	}
+	event := &AuditEvent{Action: AuditAuthentication, Decision: AuditAllow}
+	event.SetClaim(claim)
+	if err != nil {
+		event.Decision = AuditDeny
+		event.Reason = err.Error()
+	}
+	auditRequest(sink, r, start, event)
+}
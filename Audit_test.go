@@ -0,0 +1,155 @@
+package dokku_common
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingAuditSink struct {
+	mutex  sync.Mutex
+	events []*AuditEvent
+}
+
+func (s *recordingAuditSink) Audit(event *AuditEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *recordingAuditSink) reset() []*AuditEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
+func TestAuthenticator_Audit(t *testing.T) {
+	signer := newTestSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	sink := &recordingAuditSink{}
+	auth, err := NewAuthenticator(WithKeyResolver(verifier), WithAuditSink(sink))
+	assert.NoError(t, err)
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// anonymous requests let through are not decisions
+	assert.Equal(t, http.StatusNoContent, serveWithToken(handler, "").Code)
+	assert.Empty(t, sink.reset())
+
+	token := newTestToken(t, signer, &security.GoClaim{Subscriber: "user@dokku", Tokenid: "jti-1", ExpireAt: time.Now().Add(time.Hour)})
+	req := httptest.NewRequest(http.MethodGet, "/apps", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	events := sink.reset()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, AuditAuthentication, events[0].Action)
+		assert.Equal(t, AuditAllow, events[0].Decision)
+		assert.Equal(t, "user@dokku", events[0].Subject)
+		assert.Equal(t, "jti-1", events[0].TokenID)
+		assert.Equal(t, AuthMethodJWT, events[0].AuthMethod)
+		assert.Equal(t, "req-1", events[0].RequestID)
+		assert.Equal(t, "GET /apps", events[0].Route)
+		assert.False(t, events[0].Time.IsZero())
+	}
+
+	expired := newTestToken(t, signer, &security.GoClaim{Subscriber: "user@dokku", ExpireAt: time.Now().Add(-time.Minute)})
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(handler, "Bearer "+expired).Code)
+	assert.Equal(t, http.StatusBadRequest, serveWithToken(handler, "Basic abc").Code)
+	events = sink.reset()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, AuditDeny, events[0].Decision)
+		assert.Contains(t, events[0].Reason, security.ErrTokenExpired.Error())
+		assert.Equal(t, AuditDeny, events[1].Decision)
+		assert.Contains(t, events[1].Reason, ErrBearerTokenInvalid.Error())
+	}
+}
+
+func TestRequireTenantRole_Audit(t *testing.T) {
+	sink := &recordingAuditSink{}
+	DefaultAuditSink = sink
+	defer func() { DefaultAuditSink = nil }()
+	handler := RequireTenantRole("deployer", TenantFromHeader("X-Tenant"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(r *http.Request) *AuditEvent {
+		r.Header.Set("X-Tenant", "acme")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		events := sink.reset()
+		assert.Len(t, events, 1)
+		return events[0]
+	}
+
+	event := serve(withClaim(httptest.NewRequest(http.MethodPost, "/deploy", nil), "deployer@acme"))
+	assert.Equal(t, AuditAuthorization, event.Action)
+	assert.Equal(t, AuditAllow, event.Decision)
+	assert.Equal(t, "user@dokku", event.Subject)
+	assert.Equal(t, "acme", event.Tenant)
+	assert.Equal(t, "deployer", event.Role)
+	assert.Equal(t, "POST /deploy", event.Route)
+
+	event = serve(withClaim(httptest.NewRequest(http.MethodPost, "/deploy", nil), "viewer@acme"))
+	assert.Equal(t, AuditDeny, event.Decision)
+	assert.Contains(t, event.Reason, ErrInsufficientScope.Error())
+
+	event = serve(httptest.NewRequest(http.MethodPost, "/deploy", nil))
+	assert.Equal(t, AuditDeny, event.Decision)
+	assert.Equal(t, ErrTokenMissing.Error(), event.Reason)
+}
+
+func TestJSONLinesAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := OpenJSONLinesAuditFile(path)
+	assert.NoError(t, err)
+	sink.Audit(&AuditEvent{Action: AuditAuthentication, Decision: AuditAllow, Subject: "user@dokku", TokenID: "jti-1", Latency: time.Millisecond})
+	sink.Audit(&AuditEvent{Action: AuditAuthorization, Decision: AuditDeny, Tenant: "acme", Role: "deployer"})
+	assert.NoError(t, sink.Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if assert.Len(t, lines, 2) {
+		fields := make(map[string]any)
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &fields))
+		assert.Equal(t, "allow", fields["decision"])
+		assert.Equal(t, "jti-1", fields["jti"])
+		assert.Equal(t, float64(time.Millisecond), fields["latency_ns"])
+		event := &AuditEvent{}
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), event))
+		assert.Equal(t, AuditDeny, event.Decision)
+		assert.Equal(t, "acme", event.Tenant)
+	}
+}
+
+func TestLogrusAuditSink(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(buffer)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	sink := AuditSinks{&LogrusAuditSink{Logger: logger}}
+
+	sink.Audit(&AuditEvent{Action: AuditAuthorization, Decision: AuditDeny, Subject: "user@dokku", Reason: "no"})
+	fields := make(map[string]any)
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &fields))
+	assert.Equal(t, "warning", fields["level"])
+	assert.Equal(t, "user@dokku", fields["subject"])
+	assert.Equal(t, "deny", fields["decision"])
+}
@@ -0,0 +1,297 @@
+package dokku_common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenMissing = fmt.Errorf("authorization token is required")
+)
+
+// Error codes of RFC 6750, sent in the WWW-Authenticate header of refused requests.
+const (
+	BearerInvalidRequest    = "invalid_request"
+	BearerInvalidToken      = "invalid_token"
+	BearerInsufficientScope = "insufficient_scope"
+)
+
+// BearerError returns the status and RFC 6750 error code for the reason a request is
+// refused: 400 invalid_request for unreadable credentials, 401 without code for a missing
+// token, 401 invalid_token for a token refused by the TokenParser, 403 insufficient_scope
+// for a token not granting access to the resource, and 500 without code for
+// any other failure, such as an unreachable revocation store.
+func BearerError(err error) (int, string) {
+	tokenErr := &security.TokenError{}
+	switch {
+	case errors.Is(err, ErrTokenMissing):
+		return http.StatusUnauthorized, ""
+	case errors.Is(err, ErrBearerTokenInvalid):
+		return http.StatusBadRequest, BearerInvalidRequest
+	case errors.As(err, &tokenErr):
+		return http.StatusUnauthorized, BearerInvalidToken
+	case errors.Is(err, ErrInsufficientScope):
+		return http.StatusForbidden, BearerInsufficientScope
+	}
+	return http.StatusInternalServerError, ""
+}
+
+// AuthErrorWriter writes the body of a request refused by an Authenticator, with the status
+// given by BearerError. The WWW-Authenticate header is already set.
+type AuthErrorWriter func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// ProblemAuthErrorWriter is the default AuthErrorWriter. It writes RFC 7807 problem details
+// carrying the RFC 6750 error code in an "error" member, so clients can tell an expired
+// token from a missing one. The details of server errors are logged, not disclosed.
+func ProblemAuthErrorWriter(w http.ResponseWriter, r *http.Request, status int, err error) {
+	problem := NewProblem(status, err.Error())
+	problem.Instance = r.URL.Path
+	if status >= http.StatusInternalServerError {
+		logrus.Errorf("authentication of %s failed: %s", r.URL.Path, err.Error())
+		problem.Detail = ""
+	}
+	if _, code := BearerError(err); code != "" {
+		problem.Extensions = map[string]any{"error": code}
+	}
+	WriteProblem(w, problem)
+}
+
+// TextAuthErrorWriter explains the error in text/plain, like UserTokenContextMiddleware
+// used to.
+func TextAuthErrorWriter(w http.ResponseWriter, r *http.Request, status int, err error) {
+	var msg string
+	switch {
+	case errors.Is(err, ErrTokenMissing):
+		msg = "Authorization header is required"
+	case errors.Is(err, ErrBearerTokenInvalid):
+		msg = "Authorization header found, but it seems that it uses wrong bearer string"
+	default:
+		msg = fmt.Sprintf("Authorization header found, but token contains problem. %s", err.Error())
+	}
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	w.Write([]byte(msg))
+}
+
+// writeAuthError sets the RFC 6750 WWW-Authenticate challenge and lets the writer write
+// the body.
+func writeAuthError(w http.ResponseWriter, r *http.Request, realm string, writer AuthErrorWriter, err error) {
+	status, code := BearerError(err)
+	if status != http.StatusInternalServerError {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(realm, code, err))
+	}
+	writer(w, r, status, err)
+}
+
+func bearerChallenge(realm, code string, err error) string {
+	params := make([]string, 0, 3)
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", challengeValue(realm)))
+	}
+	if code != "" {
+		params = append(params, fmt.Sprintf("error=%q", code))
+		params = append(params, fmt.Sprintf("error_description=%q", challengeValue(err.Error())))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// challengeValue keeps the characters RFC 6750 allows in quoted parameters, which excludes
+// the double quote and the backslash.
+func challengeValue(value string) string {
+	return strings.Map(func(c rune) rune {
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			return -1
+		}
+		return c
+	}, value)
+}
+
+// Authenticator is a token middleware configured with AuthenticatorOption rather than the
+// package level keys, so several of them, such as one for a public API and one for an admin
+// API, can coexist with different keys and policies. Valid tokens put their claim in the
+// request context under UserClaim, like UserTokenContextMiddleware does.
+type Authenticator struct {
+	parser      *security.TokenParser
+	extractor   TokenExtractor
+	errorWriter AuthErrorWriter
+	realm       string
+	required    bool
+	audit       AuditSink
+}
+
+// AuthenticatorOption configures an Authenticator.
+type AuthenticatorOption func(*Authenticator)
+
+// WithKeyResolver verifies tokens with the key the resolver finds for them, such as a
+// *security.Verifier, a security.KeyRing or a security.JWKSResolver.
+func WithKeyResolver(resolver security.KeyResolver) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.parser.Resolver = resolver
+	}
+}
+
+// WithKeySource verifies tokens with the keys the source publishes at the time of the request.
+func WithKeySource(source security.VerificationKeySource) AuthenticatorOption {
+	return WithKeyResolver(keySourceResolver{source: source})
+}
+
+type keySourceResolver struct {
+	source security.VerificationKeySource
+}
+
+func (r keySourceResolver) ResolveKey(kid, alg string) (*security.Verifier, error) {
+	return security.StaticKeys(r.source.VerificationKeys()).ResolveKey(kid, alg)
+}
+
+// WithPolicy checks the claims of verified tokens against the policy.
+func WithPolicy(policy *security.ValidationPolicy) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.parser.Policy = policy
+	}
+}
+
+// WithRevocations refuses the tokens denied by the store.
+func WithRevocations(store security.RevocationStore) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.parser.Revocations = store
+	}
+}
+
+// WithDecrypter accepts tokens encrypted for the decrypter.
+func WithDecrypter(decrypter *security.Decrypter) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.parser.Decrypter = decrypter
+	}
+}
+
+// WithTokenExtractor takes the token from the request with the extractor instead of the
+// bearer token of the Authorization header.
+func WithTokenExtractor(extractor TokenExtractor) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.extractor = extractor
+	}
+}
+
+// WithErrorWriter writes the body of refusals with the writer instead of ProblemAuthErrorWriter.
+func WithErrorWriter(writer AuthErrorWriter) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.errorWriter = writer
+	}
+}
+
+// WithRealm names the protection space in the WWW-Authenticate challenge of refusals.
+func WithRealm(realm string) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.realm = realm
+	}
+}
+
+// WithAuditSink sends the authentication decisions to the sink instead of DefaultAuditSink.
+func WithAuditSink(sink AuditSink) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.audit = sink
+	}
+}
+
+// AuthenticationRequired refuses requests without token with 401.
+func AuthenticationRequired() AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.required = true
+	}
+}
+
+// AuthenticationOptional lets requests without token through without claim, the default.
+func AuthenticationOptional() AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.required = false
+	}
+}
+
+// NewAuthenticator creates an Authenticator. A key resolver or key source is mandatory,
+// every other option has a default: the zero ValidationPolicy, the bearer token of the
+// Authorization header, ProblemAuthErrorWriter and optional authentication.
+func NewAuthenticator(options ...AuthenticatorOption) (*Authenticator, error) {
+	a := &Authenticator{
+		parser:      &security.TokenParser{},
+		extractor:   BearerTokenExtractor,
+		errorWriter: ProblemAuthErrorWriter,
+	}
+	for _, option := range options {
+		option(a)
+	}
+	if a.parser.Resolver == nil {
+		return nil, security.ErrNoVerifier
+	}
+	return a, nil
+}
+
+// extractToken takes the token with the extractor, DefaultTokenExtractor when nil.
+func (a *Authenticator) extractToken(r *http.Request) (string, error) {
+	if a.extractor == nil {
+		return DefaultTokenExtractor.ExtractToken(r)
+	}
+	return a.extractor.ExtractToken(r)
+}
+
+// parse verifies the token with the parser, or like NewGoClaimFromToken does when nil, for
+// the UserTokenContextMiddleware.
+func (a *Authenticator) parse(token string) (*security.GoClaim, error) {
+	if a.parser == nil {
+		return security.NewGoClaimFromTokenWith(token, defaultKeyResolver())
+	}
+	return a.parser.Parse(token)
+}
+
+// Authenticate returns the claim of the request token, or nil without error when the
+// request has no token.
+func (a *Authenticator) Authenticate(r *http.Request) (*security.GoClaim, error) {
+	token, err := a.extractToken(r)
+	if err != nil || token == "" {
+		return nil, err
+	}
+	return a.parse(token)
+}
+
+// Middleware authenticates the requests before handing them to next.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		token, err := a.extractToken(r)
+		if err != nil {
+			auditToken(a.auditSink(), r, start, nil, err)
+			writeAuthError(w, r, a.realm, a.errorWriter, err)
+			return
+		}
+		if token == "" {
+			if a.required {
+				auditToken(a.auditSink(), r, start, nil, ErrTokenMissing)
+				writeAuthError(w, r, a.realm, a.errorWriter, ErrTokenMissing)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		goClaim, err := a.parse(token)
+		auditToken(a.auditSink(), r, start, goClaim, err)
+		if err != nil {
+			writeAuthError(w, r, a.realm, a.errorWriter, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithToken(r.Context(), token, goClaim)))
+	})
+}
+
+func (a *Authenticator) auditSink() AuditSink {
+	if a.audit != nil {
+		return a.audit
+	}
+	return DefaultAuditSink
+}
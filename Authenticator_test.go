@@ -0,0 +1,172 @@
+package dokku_common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) *security.Signer {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := security.NewSigner(security.SigningMethodES256, ecKey)
+	assert.NoError(t, err)
+	return signer
+}
+
+func newTestToken(t *testing.T, signer *security.Signer, claim *security.GoClaim) string {
+	token, err := claim.ToTokenWith(signer)
+	assert.NoError(t, err)
+	return token
+}
+
+func serveWithToken(handler http.Handler, header string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthenticator_Instances(t *testing.T) {
+	publicSigner, adminSigner := newTestSigner(t), newTestSigner(t)
+	publicVerifier, err := publicSigner.Verifier()
+	assert.NoError(t, err)
+	adminRing := security.NewKeyRing()
+	assert.NoError(t, adminRing.Rotate("admin-1", adminSigner, time.Hour))
+
+	publicAuth, err := NewAuthenticator(WithKeyResolver(publicVerifier))
+	assert.NoError(t, err)
+	adminAuth, err := NewAuthenticator(
+		WithKeySource(adminRing),
+		WithPolicy(&security.ValidationPolicy{Audience: []string{"admin@dokku"}}),
+		AuthenticationRequired(),
+	)
+	assert.NoError(t, err)
+
+	var subject string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = ""
+		if gc, ok := r.Context().Value(UserClaim).(*security.GoClaim); ok {
+			subject = gc.Subscriber
+		}
+	})
+	publicAPI, adminAPI := publicAuth.Middleware(handler), adminAuth.Middleware(handler)
+
+	ringSigner, err := adminRing.Signer()
+	assert.NoError(t, err)
+	publicToken := newTestToken(t, publicSigner, &security.GoClaim{Subscriber: "user@dokku", ExpireAt: time.Now().Add(time.Hour)})
+	adminToken := newTestToken(t, ringSigner, &security.GoClaim{Subscriber: "admin@dokku", Audience: []string{"admin@dokku"}, ExpireAt: time.Now().Add(time.Hour)})
+
+	assert.Equal(t, http.StatusOK, serveWithToken(publicAPI, "Bearer "+publicToken).Code)
+	assert.Equal(t, "user@dokku", subject)
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(publicAPI, "Bearer "+adminToken).Code)
+	assert.Equal(t, http.StatusOK, serveWithToken(publicAPI, "").Code)
+	assert.Equal(t, "", subject)
+
+	assert.Equal(t, http.StatusOK, serveWithToken(adminAPI, "Bearer "+adminToken).Code)
+	assert.Equal(t, "admin@dokku", subject)
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(adminAPI, "Bearer "+publicToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(adminAPI, "").Code)
+	assert.Equal(t, http.StatusBadRequest, serveWithToken(adminAPI, "Basic dXNlcjpwYXNz").Code)
+}
+
+func TestAuthenticator_Options(t *testing.T) {
+	_, err := NewAuthenticator()
+	assert.ErrorIs(t, err, security.ErrNoVerifier)
+
+	signer := newTestSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	var refused error
+	auth, err := NewAuthenticator(
+		WithKeyResolver(verifier),
+		WithTokenExtractor(&HeaderTokenExtractor{Header: "X-Api-Token", Scheme: "Token"}),
+		WithErrorWriter(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			refused = err
+			w.WriteHeader(status)
+		}),
+	)
+	assert.NoError(t, err)
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	token := newTestToken(t, signer, &security.GoClaim{Subscriber: "user@dokku", ExpireAt: time.Now().Add(-time.Minute)})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Api-Token", "Token "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.ErrorIs(t, refused, security.ErrTokenExpired)
+
+	// the Authorization header is not looked at anymore
+	assert.Equal(t, http.StatusNoContent, serveWithToken(handler, "Bearer "+token).Code)
+}
+
+func TestAuthenticator_BearerErrors(t *testing.T) {
+	signer := newTestSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	auth, err := NewAuthenticator(WithKeyResolver(verifier), WithRealm("dokku"), AuthenticationRequired())
+	assert.NoError(t, err)
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	expired := newTestToken(t, signer, &security.GoClaim{Subscriber: "user@dokku", ExpireAt: time.Now().Add(-time.Minute)})
+
+	testData := []struct {
+		header    string
+		status    int
+		challenge string
+		code      any
+	}{
+		{"", http.StatusUnauthorized, `Bearer realm="dokku"`, nil},
+		{"Basic dXNlcjpwYXNz", http.StatusBadRequest, `Bearer realm="dokku", error="invalid_request", error_description="invalid bearer token: expecting the Bearer scheme"`, "invalid_request"},
+		{"Bearer " + expired, http.StatusUnauthorized, `Bearer realm="dokku", error="invalid_token", error_description="token is expired: expired at `, "invalid_token"},
+	}
+	for _, td := range testData {
+		rec := serveWithToken(handler, td.header)
+		assert.Equal(t, td.status, rec.Code, td.header)
+		assert.True(t, strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), td.challenge), rec.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+		body := make(map[string]any)
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, td.code, body["error"])
+		assert.Equal(t, float64(td.status), body["status"])
+	}
+
+	// failures that are not the client's fault are not challenged
+	broken, err := NewAuthenticator(WithKeyResolver(verifier), WithRevocations(failingRevocations{}))
+	assert.NoError(t, err)
+	token := newTestToken(t, signer, &security.GoClaim{Subscriber: "user@dokku", ExpireAt: time.Now().Add(time.Minute)})
+	rec := serveWithToken(broken.Middleware(http.NotFoundHandler()), "Bearer "+token)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+	assert.NotContains(t, rec.Body.String(), "store is down")
+
+	// the legacy text format stays available
+	text, err := NewAuthenticator(WithKeyResolver(verifier), WithErrorWriter(TextAuthErrorWriter))
+	assert.NoError(t, err)
+	rec = serveWithToken(text.Middleware(http.NotFoundHandler()), "Bearer "+expired)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "token is expired")
+}
+
+type failingRevocations struct{}
+
+func (failingRevocations) RevokeToken(string, time.Time) error              { return nil }
+func (failingRevocations) RevokeSubject(string, time.Time, time.Time) error { return nil }
+func (failingRevocations) IsRevoked(*security.GoClaim) (bool, error) {
+	return false, fmt.Errorf("store is down")
+}
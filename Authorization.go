@@ -0,0 +1,136 @@
+package dokku_common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInsufficientScope = fmt.Errorf("token does not grant access to the resource")
+)
+
+// TenantSource finds the tenant addressed by a request, or returns an empty string.
+type TenantSource func(r *http.Request) string
+
+// TenantFrom returns the tenant of the first source finding one.
+func TenantFrom(sources ...TenantSource) TenantSource {
+	return func(r *http.Request) string {
+		for _, source := range sources {
+			if tenant := source(r); tenant != "" {
+				return tenant
+			}
+		}
+		return ""
+	}
+}
+
+// TenantFromPath takes the tenant from the path wildcard called name of a net/http ServeMux
+// pattern, such as "/tenants/{tenant}/apps". Use TenantFromPathSegment with other routers.
+func TenantFromPath(name string) TenantSource {
+	return func(r *http.Request) string {
+		return r.PathValue(name)
+	}
+}
+
+// TenantFromPathSegment takes the tenant from the segment of the URL path at index,
+// counting from zero, so index 1 of "/tenants/acme/apps" is "acme".
+func TenantFromPathSegment(index int) TenantSource {
+	return func(r *http.Request) string {
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if index < 0 || index >= len(segments) {
+			return ""
+		}
+		return segments[index]
+	}
+}
+
+// TenantFromHeader takes the tenant from the request header called name.
+func TenantFromHeader(name string) TenantSource {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// TenantFromQuery takes the tenant from the query parameter called name.
+func TenantFromQuery(name string) TenantSource {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+// TenantFromSubdomain takes the tenant from the host label right before the base domain,
+// so "acme" for "acme.api.dokku.me" with the base domain "api.dokku.me".
+func TenantFromSubdomain(baseDomain string) TenantSource {
+	suffix := "." + strings.ToLower(strings.Trim(baseDomain, "."))
+	return func(r *http.Request) string {
+		host := strings.ToLower(r.Host)
+		if h, _, found := strings.Cut(host, ":"); found {
+			host = h
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+		sub := strings.TrimSuffix(host, suffix)
+		if i := strings.LastIndex(sub, "."); i >= 0 {
+			sub = sub[i+1:]
+		}
+		return sub
+	}
+}
+
+// RequireTenantRole lets the request through only if the principal of the request, such as
+// the claim put in the context by the token middleware, has the role in the tenant the
+// source finds, as RequestMayThrough tells. Requests without principal are refused with 401,
+// the others with 403, both with the RFC 6750 challenge and the problem details of
+// ProblemAuthErrorWriter. Every decision is sent to DefaultAuditSink.
+func RequireTenantRole(role string, tenant TenantSource) func(http.Handler) http.Handler {
+	return requireTenantRoles([]string{role}, false, tenant)
+}
+
+// RequireAnyTenantRole works like RequireTenantRole, but any of the roles is enough.
+func RequireAnyTenantRole(roles []string, tenant TenantSource) func(http.Handler) http.Handler {
+	return requireTenantRoles(roles, false, tenant)
+}
+
+// RequireAllTenantRoles works like RequireTenantRole, but every one of the roles is required.
+func RequireAllTenantRoles(roles []string, tenant TenantSource) func(http.Handler) http.Handler {
+	return requireTenantRoles(roles, true, tenant)
+}
+
+func requireTenantRoles(roles []string, all bool, tenant TenantSource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			event := &AuditEvent{Action: AuditAuthorization, Decision: AuditDeny, Role: strings.Join(roles, ",")}
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				event.Reason = ErrTokenMissing.Error()
+				auditRequest(DefaultAuditSink, r, start, event)
+				writeAuthError(w, r, "", ProblemAuthErrorWriter, ErrTokenMissing)
+				return
+			}
+			event.SetPrincipal(principal)
+			tenantID := tenant(r)
+			event.Tenant = tenantID
+			if tenantID == "" {
+				err := fmt.Errorf("%w: no tenant in the request", ErrInsufficientScope)
+				event.Reason = err.Error()
+				auditRequest(DefaultAuditSink, r, start, event)
+				writeAuthError(w, r, "", ProblemAuthErrorWriter, err)
+				return
+			}
+			if !PrincipalHasRoles(principal, tenantID, roles, all) {
+				err := fmt.Errorf("%w: roles %s in tenant %s", ErrInsufficientScope, strings.Join(roles, ","), tenantID)
+				event.Reason = err.Error()
+				auditRequest(DefaultAuditSink, r, start, event)
+				writeAuthError(w, r, "", ProblemAuthErrorWriter, err)
+				return
+			}
+			event.Decision = AuditAllow
+			auditRequest(DefaultAuditSink, r, start, event)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
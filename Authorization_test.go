@@ -0,0 +1,82 @@
+package dokku_common
+
+import (
+	"context"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withClaim(r *http.Request, audience ...string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), UserClaim, &security.GoClaim{Subscriber: "user@dokku", Audience: audience}))
+}
+
+func TestTenantSources(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://acme.api.dokku.me:8080/tenants/globex/apps?tenant=initech", nil)
+	req.Header.Set("X-Tenant", " umbrella ")
+
+	assert.Equal(t, "globex", TenantFromPathSegment(1)(req))
+	assert.Equal(t, "", TenantFromPathSegment(5)(req))
+	assert.Equal(t, "umbrella", TenantFromHeader("X-Tenant")(req))
+	assert.Equal(t, "initech", TenantFromQuery("tenant")(req))
+	assert.Equal(t, "acme", TenantFromSubdomain("api.dokku.me")(req))
+	assert.Equal(t, "", TenantFromSubdomain("dokku.io")(req))
+	assert.Equal(t, "initech", TenantFrom(TenantFromHeader("X-Missing"), TenantFromQuery("tenant"))(req))
+}
+
+func TestTenantFromPath(t *testing.T) {
+	var tenant string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenants/{tenant}/apps", func(w http.ResponseWriter, r *http.Request) {
+		tenant = TenantFromPath("tenant")(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tenants/acme/apps", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "acme", tenant)
+	assert.Equal(t, "", TenantFromPath("tenant")(httptest.NewRequest(http.MethodGet, "/tenants/acme/apps", nil)))
+}
+
+func TestRequireTenantRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tenant := TenantFromPathSegment(1)
+	single := RequireTenantRole("admin", tenant)(ok)
+	anyOf := RequireAnyTenantRole([]string{"admin", "dev"}, tenant)(ok)
+	allOf := RequireAllTenantRoles([]string{"admin", "dev"}, tenant)(ok)
+
+	testData := []struct {
+		handler  http.Handler
+		path     string
+		audience []string
+		status   int
+	}{
+		{single, "/tenants/acme", []string{"admin@acme"}, http.StatusNoContent},
+		{single, "/tenants/acme", []string{"admin@globex"}, http.StatusForbidden},
+		{single, "/tenants/acme", []string{"dev@acme"}, http.StatusForbidden},
+		{single, "/tenants", []string{"admin@acme"}, http.StatusForbidden},
+		{single, "/tenants/acme", nil, http.StatusUnauthorized},
+		{anyOf, "/tenants/acme", []string{"dev@acme"}, http.StatusNoContent},
+		{anyOf, "/tenants/acme", []string{"ops@acme"}, http.StatusForbidden},
+		{allOf, "/tenants/acme", []string{"dev@acme"}, http.StatusForbidden},
+		{allOf, "/tenants/acme", []string{"dev,admin@acme"}, http.StatusNoContent},
+		{allOf, "/tenants/acme", []string{"dev@acme", "admin@acme"}, http.StatusNoContent},
+	}
+	for _, td := range testData {
+		req := httptest.NewRequest(http.MethodGet, td.path, nil)
+		if td.audience != nil {
+			req = withClaim(req, td.audience...)
+		}
+		rec := httptest.NewRecorder()
+		td.handler.ServeHTTP(rec, req)
+		assert.Equal(t, td.status, rec.Code, "%s %v", td.path, td.audience)
+		if td.status == http.StatusForbidden {
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+			assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,799 @@
+package dokku_common
+
+import (
+	"bytes"
+	"context"
+	gocrypto "crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/newm4n/dokku-common/security"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignatureMissing              = fmt.Errorf("request is not signed")
+	ErrSignatureInvalid              = fmt.Errorf("invalid request signature")
+	ErrSignatureExpired              = fmt.Errorf("request signature is not fresh")
+	ErrContentDigestMismatch         = fmt.Errorf("request body does not match its content digest")
+	ErrUnsupportedSignatureAlgorithm = fmt.Errorf("signing method not supported for HTTP message signatures")
+	ErrBodyTooLarge                  = fmt.Errorf("request body is too large to be digested")
+)
+
+// defaultMaxBodySize limits the bodies read for their content digest when MaxBodySize is zero.
+const defaultMaxBodySize = 1 << 20
+
+// readBody reads the body, refusing bodies larger than maxBodySize, defaultMaxBodySize when
+// zero, with ErrBodyTooLarge.
+func readBody(body io.Reader, maxBodySize int64) ([]byte, error) {
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBodySize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxBodySize)
+	}
+	return data, nil
+}
+
+// DefaultSignatureComponents are the components a SigningTransport signs and a
+// SignatureVerifier requires by default: the method, the target URI split in authority,
+// path and query, the digest of the body and the date.
+var DefaultSignatureComponents = []string{"@method", "@authority", "@path", "@query", "content-digest", "date"}
+
+// signatureAlgorithms maps the RFC 9421 algorithm names to the JWS algorithm of the
+// signing methods of the security package.
+var signatureAlgorithms = map[string]string{
+	"rsa-pss-sha512":    "PS512",
+	"rsa-v1_5-sha256":   "RS256",
+	"hmac-sha256":       "HS256",
+	"ecdsa-p256-sha256": "ES256",
+	"ecdsa-p384-sha384": "ES384",
+	"ed25519":           "EdDSA",
+}
+
+// signatureAlgorithm returns the RFC 9421 name of the signing method.
+func signatureAlgorithm(method crypto.SigningMethod) (string, error) {
+	for name, alg := range signatureAlgorithms {
+		if method != nil && method.Alg() == alg {
+			return name, nil
+		}
+	}
+	return "", ErrUnsupportedSignatureAlgorithm
+}
+
+// pssOptions are the RSASSA-PSS parameters of rsa-pss-sha512, a salt as long as the hash.
+var pssOptions = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: gocrypto.SHA512}
+
+func signSignatureBase(signer *security.Signer, base []byte) ([]byte, error) {
+	if signer.Method.Alg() == "PS512" {
+		key, ok := signer.Key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, security.ErrKeyNotSuitable
+		}
+		sum := sha512.Sum512(base)
+		return rsa.SignPSS(rand.Reader, key, gocrypto.SHA512, sum[:], pssOptions)
+	}
+	signature, err := signer.Method.Sign(base, signer.Key)
+	return signature, err
+}
+
+func verifySignatureBase(verifier *security.Verifier, base, signature []byte) error {
+	if verifier.Method.Alg() == "PS512" {
+		key, ok := verifier.Key.(*rsa.PublicKey)
+		if !ok {
+			return security.ErrKeyNotSuitable
+		}
+		sum := sha512.Sum512(base)
+		return rsa.VerifyPSS(key, gocrypto.SHA512, sum[:], signature, pssOptions)
+	}
+	return verifier.Method.Verify(base, signature, verifier.Key)
+}
+
+// sfToken is a structured field token, serialized without quotes.
+type sfToken string
+
+type signatureParam struct {
+	name  string
+	value any
+}
+
+// signatureParams are the covered components and the parameters of a signature, the
+// value of its Signature-Input member and of the "@signature-params" line of its base.
+type signatureParams struct {
+	components []string
+	params     []signatureParam
+}
+
+func (sp *signatureParams) set(name string, value any) {
+	sp.params = append(sp.params, signatureParam{name: name, value: value})
+}
+
+func (sp *signatureParams) get(name string) any {
+	for _, p := range sp.params {
+		if p.name == name {
+			return p.value
+		}
+	}
+	return nil
+}
+
+func (sp *signatureParams) getString(name string) string {
+	s, _ := sp.get(name).(string)
+	return s
+}
+
+func (sp *signatureParams) covers(component string) bool {
+	return slices.Contains(sp.components, component)
+}
+
+// String serializes the parameters as an RFC 8941 inner list.
+func (sp *signatureParams) String() string {
+	b := &strings.Builder{}
+	b.WriteByte('(')
+	for i, component := range sp.components {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		writeSFString(b, component)
+	}
+	b.WriteByte(')')
+	for _, p := range sp.params {
+		b.WriteByte(';')
+		b.WriteString(p.name)
+		switch v := p.value.(type) {
+		case bool:
+			if !v {
+				b.WriteString("=?0")
+			}
+		case int64:
+			b.WriteByte('=')
+			b.WriteString(strconv.FormatInt(v, 10))
+		case sfToken:
+			b.WriteByte('=')
+			b.WriteString(string(v))
+		case string:
+			b.WriteByte('=')
+			writeSFString(b, v)
+		}
+	}
+	return b.String()
+}
+
+func writeSFString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, c := range s {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+}
+
+type dictionaryMember struct {
+	label string
+	value string
+}
+
+// parseDictionary splits an RFC 8941 dictionary, such as a Signature-Input header, in its
+// members, in order, leaving their values to be parsed.
+func parseDictionary(field string) ([]dictionaryMember, error) {
+	members := make([]dictionaryMember, 0, 1)
+	start, depth, quoted, escaped := 0, 0, false, false
+	split := func(end int) error {
+		member := strings.TrimSpace(field[start:end])
+		if member == "" {
+			return nil
+		}
+		label, value, found := strings.Cut(member, "=")
+		if !found || label == "" {
+			return fmt.Errorf("%w: malformed dictionary member %q", ErrSignatureInvalid, member)
+		}
+		members = append(members, dictionaryMember{label: strings.TrimSpace(label), value: strings.TrimSpace(value)})
+		return nil
+	}
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			if err := split(i); err != nil {
+				return nil, err
+			}
+			start = i + 1
+		}
+	}
+	if quoted || depth != 0 {
+		return nil, fmt.Errorf("%w: unterminated dictionary", ErrSignatureInvalid)
+	}
+	if err := split(len(field)); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// sfParser reads the items of an RFC 8941 structured field.
+type sfParser struct {
+	s   string
+	pos int
+}
+
+func (p *sfParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *sfParser) skipSpaces() {
+	for p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) parseString() (string, error) {
+	if p.peek() != '"' {
+		return "", fmt.Errorf("%w: expecting a string at %d", ErrSignatureInvalid, p.pos)
+	}
+	p.pos++
+	b := &strings.Builder{}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\' && p.pos < len(p.s):
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", fmt.Errorf("%w: invalid character in string", ErrSignatureInvalid)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("%w: unterminated string", ErrSignatureInvalid)
+}
+
+func (p *sfParser) parseKey() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.' || c == '*') {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *sfParser) parseValue() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.parseString()
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		p.pos++
+		for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+			p.pos++
+		}
+		return strconv.ParseInt(p.s[start:p.pos], 10, 64)
+	case c == '?':
+		if p.pos+1 >= len(p.s) || (p.s[p.pos+1] != '0' && p.s[p.pos+1] != '1') {
+			return nil, fmt.Errorf("%w: malformed boolean", ErrSignatureInvalid)
+		}
+		p.pos += 2
+		return p.s[p.pos-1] == '1', nil
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '*':
+		start := p.pos
+		for p.pos < len(p.s) && !strings.ContainsRune(" ;,()\"", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		return sfToken(p.s[start:p.pos]), nil
+	}
+	return nil, fmt.Errorf("%w: unexpected %q at %d", ErrSignatureInvalid, c, p.pos)
+}
+
+func (p *sfParser) parseParams() ([]signatureParam, error) {
+	params := make([]signatureParam, 0, 4)
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSpaces()
+		name := p.parseKey()
+		if name == "" {
+			return nil, fmt.Errorf("%w: malformed parameter at %d", ErrSignatureInvalid, p.pos)
+		}
+		var value any = true
+		if p.peek() == '=' {
+			p.pos++
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		}
+		params = append(params, signatureParam{name: name, value: value})
+	}
+	return params, nil
+}
+
+// parseSignatureParams parses the value of a Signature-Input member. Components with
+// parameters, such as "@query-param";name="id", are not supported.
+func parseSignatureParams(value string) (*signatureParams, error) {
+	p := &sfParser{s: value}
+	if p.peek() != '(' {
+		return nil, fmt.Errorf("%w: expecting an inner list", ErrSignatureInvalid)
+	}
+	p.pos++
+	sp := &signatureParams{components: make([]string, 0, 8)}
+	for {
+		p.skipSpaces()
+		if p.peek() == ')' {
+			p.pos++
+			break
+		}
+		component, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() == ';' {
+			return nil, fmt.Errorf("%w: parameters of component %s not supported", ErrSignatureInvalid, component)
+		}
+		sp.components = append(sp.components, component)
+	}
+	params, err := p.parseParams()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("%w: trailing characters after the signature parameters", ErrSignatureInvalid)
+	}
+	sp.params = params
+	return sp, nil
+}
+
+// parseByteSequence decodes an RFC 8941 byte sequence, ignoring its parameters.
+func parseByteSequence(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, ":") {
+		return nil, fmt.Errorf("%w: expecting a byte sequence", ErrSignatureInvalid)
+	}
+	encoded, _, found := strings.Cut(value[1:], ":")
+	if !found {
+		return nil, fmt.Errorf("%w: unterminated byte sequence", ErrSignatureInvalid)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// signatureComponent returns the value of a component of the request, the same way for
+// outgoing requests, whose URL has a scheme and host, and incoming ones.
+func signatureComponent(r *http.Request, component string) (string, error) {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	authority := r.Host
+	if authority == "" {
+		authority = r.URL.Host
+	}
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	target := path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	switch component {
+	case "@method":
+		return strings.ToUpper(r.Method), nil
+	case "@target-uri":
+		return strings.ToLower(scheme) + "://" + strings.ToLower(authority) + target, nil
+	case "@authority":
+		return strings.ToLower(authority), nil
+	case "@scheme":
+		return strings.ToLower(scheme), nil
+	case "@request-target":
+		return target, nil
+	case "@path":
+		return path, nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") || component != strings.ToLower(component) {
+		return "", fmt.Errorf("%w: component %s not supported", ErrSignatureInvalid, component)
+	}
+	values := r.Header.Values(component)
+	// net/http moves these headers of incoming requests out of the header map
+	switch {
+	case len(values) == 0 && component == "host" && r.Host != "":
+		values = []string{r.Host}
+	case len(values) == 0 && component == "content-length" && r.ContentLength >= 0 && r.Body != nil && r.Body != http.NoBody:
+		values = []string{strconv.FormatInt(r.ContentLength, 10)}
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: header %s is covered but missing", ErrSignatureInvalid, component)
+	}
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+// signatureBase builds the RFC 9421 signature base of the request covering the components.
+func signatureBase(r *http.Request, sp *signatureParams) ([]byte, error) {
+	b := &strings.Builder{}
+	for _, component := range sp.components {
+		value, err := signatureComponent(r, component)
+		if err != nil {
+			return nil, err
+		}
+		writeSFString(b, component)
+		b.WriteString(": " + value + "\n")
+	}
+	b.WriteString("\"@signature-params\": " + sp.String())
+	return []byte(b.String()), nil
+}
+
+// contentDigest returns the RFC 9530 Content-Digest of the body with SHA-256.
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// checkContentDigest checks the body against the SHA-256 or SHA-512 digests of the
+// Content-Digest header, other algorithms are ignored.
+func checkContentDigest(header string, body []byte) error {
+	members, err := parseDictionary(header)
+	if err != nil {
+		return err
+	}
+	checked := false
+	for _, member := range members {
+		var sum []byte
+		switch member.label {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		digest, err := parseByteSequence(member.value)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(digest, sum) != 1 {
+			return ErrContentDigestMismatch
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("%w: no sha-256 or sha-512 digest", ErrContentDigestMismatch)
+	}
+	return nil
+}
+
+// SigningTransport is an http.RoundTripper signing the outgoing requests with RFC 9421 HTTP
+// message signatures, for service to service calls. The signature covers the Components,
+// DefaultSignatureComponents without them, under the Label, "sig1" without it. A Date header
+// is added when the date is covered, and a Content-Digest header when the digest of the body
+// is. Requests are signed with the current signer of the SignerSource, whose key ID is sent
+// as "keyid". Base is http.DefaultTransport when nil.
+type SigningTransport struct {
+	Base       http.RoundTripper
+	Signer     security.SignerSource
+	Label      string
+	Components []string
+	// MaxBodySize limits the body read for its digest, 1 MiB when zero.
+	MaxBodySize int64
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewSigningTransport creates a SigningTransport over base signing the default components.
+func NewSigningTransport(base http.RoundTripper, signer security.SignerSource) *SigningTransport {
+	return &SigningTransport{Base: base, Signer: signer}
+}
+
+func (t *SigningTransport) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed, err := t.sign(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// sign returns a signed clone of the request, which must not be modified.
+func (t *SigningTransport) sign(req *http.Request) (*http.Request, error) {
+	signer, err := t.Signer.Signer()
+	if err != nil {
+		return nil, err
+	}
+	alg, err := signatureAlgorithm(signer.Method)
+	if err != nil {
+		return nil, err
+	}
+	components := t.Components
+	if len(components) == 0 {
+		components = DefaultSignatureComponents
+	}
+	label := t.Label
+	if label == "" {
+		label = "sig1"
+	}
+	now := t.now()
+
+	signed := req.Clone(req.Context())
+	if slices.Contains(components, "content-digest") {
+		body := []byte{}
+		if req.Body != nil && req.Body != http.NoBody {
+			body, err = readBody(req.Body, t.MaxBodySize)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+		signed.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		signed.Header.Set("Content-Digest", contentDigest(body))
+	}
+	if slices.Contains(components, "date") && signed.Header.Get("Date") == "" {
+		signed.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+
+	sp := &signatureParams{components: components}
+	sp.set("created", now.Unix())
+	if signer.KeyID != "" {
+		sp.set("keyid", signer.KeyID)
+	}
+	sp.set("alg", alg)
+	base, err := signatureBase(signed, sp)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signSignatureBase(signer, base)
+	if err != nil {
+		return nil, err
+	}
+	signed.Header.Add("Signature-Input", label+"="+sp.String())
+	signed.Header.Add("Signature", label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return signed, nil
+}
+
+type signerContextKey struct{}
+
+// SignerFromContext returns the key ID of the signature a SignatureVerifier verified.
+func SignerFromContext(ctx context.Context) (string, bool) {
+	keyID, ok := ctx.Value(signerContextKey{}).(string)
+	return keyID, ok
+}
+
+// SignatureVerifier is a middleware verifying the RFC 9421 HTTP message signatures of
+// incoming requests with the keys the Resolver finds from their "keyid" and "alg". The
+// signature under Label, or the first one without it, must cover every Required component,
+// DefaultSignatureComponents when empty, except "content-digest" for requests without body.
+// A covered digest must match the body. Its "created" time must be within MaxAge of the
+// current time, five minutes when zero. The key ID of the signature
+// is put in the context, readable with SignerFromContext. Refused requests get 401 with
+// problem details, 413 when the body is larger than MaxBodySize, decisions are sent to
+// Audit, or DefaultAuditSink when nil.
+type SignatureVerifier struct {
+	Resolver security.KeyResolver
+	Label    string
+	Required []string
+	MaxAge   time.Duration
+	Audit    AuditSink
+	// MaxBodySize limits the body read for its digest, 1 MiB when zero.
+	MaxBodySize int64
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewSignatureVerifier creates a SignatureVerifier requiring the default components and
+// signatures created less than five minutes ago.
+func NewSignatureVerifier(resolver security.KeyResolver) *SignatureVerifier {
+	return &SignatureVerifier{Resolver: resolver, Required: DefaultSignatureComponents, MaxAge: 5 * time.Minute}
+}
+
+func (v *SignatureVerifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+func (v *SignatureVerifier) required() []string {
+	if len(v.Required) > 0 {
+		return v.Required
+	}
+	return DefaultSignatureComponents
+}
+
+func (v *SignatureVerifier) maxAge() time.Duration {
+	if v.MaxAge > 0 {
+		return v.MaxAge
+	}
+	return 5 * time.Minute
+}
+
+// Verify checks the signature of the request and returns the key ID it was signed with.
+// The body is read to check its digest and replaced by a copy.
+func (v *SignatureVerifier) Verify(r *http.Request) (string, error) {
+	inputs := strings.Join(r.Header.Values("Signature-Input"), ", ")
+	signatures := strings.Join(r.Header.Values("Signature"), ", ")
+	if inputs == "" || signatures == "" {
+		return "", ErrSignatureMissing
+	}
+	inputMembers, err := parseDictionary(inputs)
+	if err != nil {
+		return "", err
+	}
+	signatureMembers, err := parseDictionary(signatures)
+	if err != nil {
+		return "", err
+	}
+	label := v.Label
+	if label == "" && len(inputMembers) > 0 {
+		label = inputMembers[0].label
+	}
+	input, signatureValue := "", ""
+	for _, member := range inputMembers {
+		if member.label == label {
+			input = member.value
+		}
+	}
+	for _, member := range signatureMembers {
+		if member.label == label {
+			signatureValue = member.value
+		}
+	}
+	if input == "" || signatureValue == "" {
+		return "", fmt.Errorf("%w: no signature %s", ErrSignatureMissing, label)
+	}
+	sp, err := parseSignatureParams(input)
+	if err != nil {
+		return "", err
+	}
+	signature, err := parseByteSequence(signatureValue)
+	if err != nil {
+		return "", err
+	}
+
+	hasBody := r.ContentLength != 0 && r.Body != nil && r.Body != http.NoBody
+	for _, component := range v.required() {
+		if component == "content-digest" && !hasBody {
+			continue
+		}
+		if !sp.covers(component) {
+			return "", fmt.Errorf("%w: %s is not covered", ErrSignatureInvalid, component)
+		}
+	}
+
+	now := v.now()
+	created, ok := sp.get("created").(int64)
+	if !ok {
+		return "", fmt.Errorf("%w: no created time", ErrSignatureInvalid)
+	}
+	if age := now.Sub(time.Unix(created, 0)); age > v.maxAge() || age < -time.Minute {
+		return "", fmt.Errorf("%w: created %s ago", ErrSignatureExpired, age.Truncate(time.Second))
+	}
+	if expires, ok := sp.get("expires").(int64); ok && !now.Before(time.Unix(expires, 0)) {
+		return "", fmt.Errorf("%w: expired", ErrSignatureExpired)
+	}
+
+	keyID, alg := sp.getString("keyid"), sp.getString("alg")
+	jwsAlg := ""
+	if alg != "" {
+		if jwsAlg, ok = signatureAlgorithms[alg]; !ok {
+			return "", fmt.Errorf("%w: %s", ErrUnsupportedSignatureAlgorithm, alg)
+		}
+	}
+	verifier, err := v.Resolver.ResolveKey(keyID, jwsAlg)
+	if err != nil {
+		return "", fmt.Errorf("%w: key %s: %s", ErrSignatureInvalid, keyID, err.Error())
+	}
+	if jwsAlg != "" && verifier.Method.Alg() != jwsAlg {
+		return "", fmt.Errorf("%w: key %s is not a %s key", ErrSignatureInvalid, keyID, alg)
+	}
+	base, err := signatureBase(r, sp)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignatureBase(verifier, base, signature); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrSignatureInvalid, err.Error())
+	}
+
+	if sp.covers("content-digest") {
+		body := []byte{}
+		if r.Body != nil {
+			body, err = readBody(r.Body, v.MaxBodySize)
+			r.Body.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := checkContentDigest(r.Header.Get("Content-Digest"), body); err != nil {
+			return "", err
+		}
+	}
+	if keyID == "" {
+		keyID = verifier.KeyID
+	}
+	return keyID, nil
+}
+
+// Middleware verifies the signatures of the requests before handing them to next.
+func (v *SignatureVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		keyID, err := v.Verify(r)
+		sink := v.Audit
+		if sink == nil {
+			sink = DefaultAuditSink
+		}
+		event := &AuditEvent{Action: AuditAuthentication, Decision: AuditAllow, Subject: keyID, AuthMethod: AuthMethodHTTPSignature}
+		if err != nil {
+			event.Decision, event.Reason = AuditDeny, err.Error()
+		}
+		auditRequest(sink, r, start, event)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrBodyTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			WriteProblem(w, NewProblem(status, err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signerContextKey{}, keyID)))
+	})
+}
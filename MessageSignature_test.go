@@ -0,0 +1,208 @@
+package dokku_common
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// signRequest returns the request as the transport sends it.
+func signRequest(t *testing.T, transport *SigningTransport, req *http.Request) *http.Request {
+	var sent *http.Request
+	transport.Base = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+	})
+	_, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	return sent
+}
+
+func TestSignatureParams(t *testing.T) {
+	input := `("@method" "@authority" "@path" "x-a\"b");created=1618884473;keyid="test-key";nonce=abc;flag`
+	sp, err := parseSignatureParams(input)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"@method", "@authority", "@path", `x-a"b`}, sp.components)
+	assert.Equal(t, int64(1618884473), sp.get("created"))
+	assert.Equal(t, "test-key", sp.getString("keyid"))
+	assert.Equal(t, input, sp.String())
+
+	_, err = parseSignatureParams(`("@query-param";name="id")`)
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	members, err := parseDictionary(`sig1=("@method");keyid="a,b", sig2=("@path")`)
+	assert.NoError(t, err)
+	if assert.Len(t, members, 2) {
+		assert.Equal(t, "sig1", members[0].label)
+		assert.Equal(t, `("@method");keyid="a,b"`, members[0].value)
+		assert.Equal(t, "sig2", members[1].label)
+	}
+}
+
+// TestSignatureVerifier_RFC9421 checks the Ed25519 example of RFC 9421 section B.2.6.
+func TestSignatureVerifier_RFC9421(t *testing.T) {
+	der, err := base64.StdEncoding.DecodeString("MCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=")
+	assert.NoError(t, err)
+	public, err := x509.ParsePKIXPublicKey(der)
+	assert.NoError(t, err)
+	verifier, err := security.NewVerifier(security.SigningMethodEdDSA, public)
+	assert.NoError(t, err)
+	verifier.KeyID = "test-key-ed25519"
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Signature-Input", `sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`)
+	req.Header.Set("Signature", "sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:")
+
+	sv := &SignatureVerifier{
+		Resolver: verifier,
+		Required: []string{"date", "@method", "@path", "@authority"},
+		MaxAge:   time.Minute,
+		Now:      func() time.Time { return time.Unix(1618884473, 0) },
+	}
+	keyID, err := sv.Verify(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-key-ed25519", keyID)
+}
+
+func TestSignatureVerifier_ZeroValue(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := security.NewSigner(security.SigningMethodES256, ecKey)
+	assert.NoError(t, err)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "http://api.dokku.me/apps", strings.NewReader("name=web"))
+	}
+	now := time.Now()
+	sv := &SignatureVerifier{Resolver: verifier, Now: func() time.Time { return now }}
+
+	_, err = sv.Verify(signRequest(t, NewSigningTransport(nil, signer), newRequest()))
+	assert.NoError(t, err)
+
+	// the default components are required, a signature of the method alone can be replayed anywhere
+	_, err = sv.Verify(signRequest(t, &SigningTransport{Signer: signer, Components: []string{"@method"}}, newRequest()))
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	// signatures are fresh for five minutes
+	signed := signRequest(t, NewSigningTransport(nil, signer), newRequest())
+	now = now.Add(4 * time.Minute)
+	_, err = sv.Verify(signed)
+	assert.NoError(t, err)
+	signed = signRequest(t, &SigningTransport{Signer: signer, Now: func() time.Time { return now.Add(-6 * time.Minute) }}, newRequest())
+	_, err = sv.Verify(signed)
+	assert.ErrorIs(t, err, ErrSignatureExpired)
+}
+
+func TestSigningTransport(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := security.NewSigner(security.SigningMethodES256, ecKey)
+	assert.NoError(t, err)
+	signer.KeyID = "billing"
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+
+	var signerID, body string
+	server := httptest.NewServer(NewSignatureVerifier(verifier).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signerID, _ = SignerFromContext(r.Context())
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewSigningTransport(nil, signer)}
+	res, err := client.Post(server.URL+"/invoices?tenant=acme", "application/json", strings.NewReader(`{"amount":10}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "billing", signerID)
+	assert.Equal(t, `{"amount":10}`, body)
+
+	res, err = http.Post(server.URL+"/invoices", "application/json", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, ProblemContentType, res.Header.Get("Content-Type"))
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	signer, err := security.NewSigner(crypto.SigningMethodPS512, rsaKey)
+	assert.NoError(t, err)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	otherSigner, err := security.NewSigner(security.SigningMethodEdDSA, edKey)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	sv := NewSignatureVerifier(verifier)
+	sv.Now = func() time.Time { return now }
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodPut, "http://api.dokku.me/apps/web?force=1", strings.NewReader("replicas=3"))
+	}
+
+	signed := signRequest(t, NewSigningTransport(nil, signer), newRequest())
+	assert.Contains(t, signed.Header.Get("Signature-Input"), `alg="rsa-pss-sha512"`)
+	assert.NotEmpty(t, signed.Header.Get("Date"))
+	_, err = sv.Verify(signed)
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(signed.Body)
+	assert.Equal(t, "replicas=3", string(b))
+
+	testData := []struct {
+		name   string
+		tamper func(r *http.Request)
+		err    error
+	}{
+		{"body", func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader("replicas=30")) }, ErrContentDigestMismatch},
+		{"method", func(r *http.Request) { r.Method = http.MethodDelete }, ErrSignatureInvalid},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "force=0" }, ErrSignatureInvalid},
+		{"date", func(r *http.Request) { r.Header.Del("Date") }, ErrSignatureInvalid},
+		{"unsigned", func(r *http.Request) { r.Header.Del("Signature") }, ErrSignatureMissing},
+		{"stale", func(r *http.Request) { sv.Now = func() time.Time { return now.Add(10 * time.Minute) } }, ErrSignatureExpired},
+	}
+	for _, td := range testData {
+		req := signRequest(t, NewSigningTransport(nil, signer), newRequest())
+		td.tamper(req)
+		_, err := sv.Verify(req)
+		assert.ErrorIs(t, err, td.err, td.name)
+		sv.Now = func() time.Time { return now }
+	}
+
+	_, err = sv.Verify(signRequest(t, NewSigningTransport(nil, otherSigner), newRequest()))
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+	_, err = sv.Verify(signRequest(t, &SigningTransport{Signer: signer, Components: []string{"@method", "@path"}}, newRequest()))
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	// bodies larger than MaxBodySize are neither signed nor digested
+	_, err = (&SigningTransport{Signer: signer, MaxBodySize: 5}).RoundTrip(newRequest())
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	sv.MaxBodySize = 5
+	rec := httptest.NewRecorder()
+	sv.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, signRequest(t, NewSigningTransport(nil, signer), newRequest()))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -0,0 +1,206 @@
+package dokku_common
+
+import (
+	"context"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/sirupsen/logrus"
+	"slices"
+)
+
+// AuthMethod tells how a Principal was authenticated.
+type AuthMethod string
+
+const (
+	AuthMethodJWT      AuthMethod = "jwt"
+	AuthMethodAPIKey   AuthMethod = "api_key"
+	AuthMethodMTLS     AuthMethod = "mtls"
+	AuthMethodInternal AuthMethod = "internal"
+	// AuthMethodHTTPSignature is the RFC 9421 HTTP message signature of the request.
+	AuthMethodHTTPSignature AuthMethod = "http_signature"
+)
+
+// Principal is the authenticated caller of a request or job, whatever the way it
+// authenticated. A tenant or role "*" stands for any tenant or role.
+type Principal interface {
+	Subject() string
+	Tenants() []string
+	// Roles returns the roles of the principal in the tenant.
+	Roles(tenant string) []string
+	TokenType() security.TokenType
+	AuthMethod() AuthMethod
+}
+
+// PrincipalHasRole tells whether the principal has the role in the tenant.
+func PrincipalHasRole(p Principal, tenant, role string) bool {
+	if p == nil || tenant == "" || role == "" {
+		return false
+	}
+	for _, r := range p.Roles(tenant) {
+		if r == "*" || r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalHasRoles tells whether the principal has any of the roles in the tenant, or all
+// of them when all is set.
+func PrincipalHasRoles(p Principal, tenant string, roles []string, all bool) bool {
+	if len(roles) == 0 {
+		return false
+	}
+	for _, role := range roles {
+		granted := PrincipalHasRole(p, tenant, role)
+		if granted != all {
+			return granted
+		}
+	}
+	return all
+}
+
+// ClaimPrincipal is the Principal of a token claim, whose tenants and roles are the
+// "roles@tenants" entries of its audience.
+type ClaimPrincipal struct {
+	Claim  *security.GoClaim
+	Method AuthMethod
+}
+
+// NewClaimPrincipal creates the Principal of a JWT claim.
+func NewClaimPrincipal(claim *security.GoClaim) *ClaimPrincipal {
+	return &ClaimPrincipal{Claim: claim, Method: AuthMethodJWT}
+}
+
+func (cp *ClaimPrincipal) tenantRoles() []*security.TenantRole {
+	ret := make([]*security.TenantRole, 0, len(cp.Claim.Audience))
+	for _, aud := range cp.Claim.Audience {
+		tr, err := security.NewTenantRole(aud)
+		if err != nil {
+			logrus.Debugf("error while creating tenant-role got %s", err.Error())
+			continue
+		}
+		ret = append(ret, tr)
+	}
+	return ret
+}
+
+func (cp *ClaimPrincipal) Subject() string {
+	return cp.Claim.Subscriber
+}
+
+func (cp *ClaimPrincipal) Tenants() []string {
+	ret := make([]string, 0)
+	for _, tr := range cp.tenantRoles() {
+		for _, tenant := range tr.Tenants() {
+			if !slices.Contains(ret, tenant) {
+				ret = append(ret, tenant)
+			}
+		}
+	}
+	return ret
+}
+
+func (cp *ClaimPrincipal) Roles(tenant string) []string {
+	ret := make([]string, 0)
+	for _, tr := range cp.tenantRoles() {
+		if !slices.Contains(tr.Tenants(), tenant) && !slices.Contains(tr.Tenants(), "*") {
+			continue
+		}
+		for _, role := range tr.Roles() {
+			if !slices.Contains(ret, role) {
+				ret = append(ret, role)
+			}
+		}
+	}
+	return ret
+}
+
+func (cp *ClaimPrincipal) TokenType() security.TokenType {
+	return cp.Claim.TokenType
+}
+
+func (cp *ClaimPrincipal) AuthMethod() AuthMethod {
+	return cp.Method
+}
+
+// StaticPrincipal is a Principal with fixed tenants and roles, for API keys, client
+// certificates, or jobs acting on behalf of a service. TenantRoles maps each tenant to
+// the roles of the principal in it.
+type StaticPrincipal struct {
+	SubjectID   string
+	TenantRoles map[string][]string
+	Type        security.TokenType
+	Method      AuthMethod
+}
+
+func (sp *StaticPrincipal) Subject() string {
+	return sp.SubjectID
+}
+
+func (sp *StaticPrincipal) Tenants() []string {
+	ret := make([]string, 0, len(sp.TenantRoles))
+	for tenant := range sp.TenantRoles {
+		ret = append(ret, tenant)
+	}
+	return ret
+}
+
+func (sp *StaticPrincipal) Roles(tenant string) []string {
+	ret := make([]string, 0)
+	ret = append(ret, sp.TenantRoles[tenant]...)
+	if tenant != "*" {
+		ret = append(ret, sp.TenantRoles["*"]...)
+	}
+	return ret
+}
+
+func (sp *StaticPrincipal) TokenType() security.TokenType {
+	return sp.Type
+}
+
+func (sp *StaticPrincipal) AuthMethod() AuthMethod {
+	return sp.Method
+}
+
+type principalContextKey struct{}
+
+type tokenContextKey struct{}
+
+// WithPrincipal returns a context carrying the principal, for code that is not behind the
+// token middlewares, such as jobs.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// WithToken returns a context carrying the token, its claim, and the ClaimPrincipal of the
+// claim, as the token middlewares do. UserAuthorization and UserClaim are set too.
+func WithToken(ctx context.Context, token string, claim *security.GoClaim) context.Context {
+	ctx = context.WithValue(ctx, UserAuthorization, "Bearer "+token)
+	ctx = context.WithValue(ctx, UserClaim, claim)
+	ctx = context.WithValue(ctx, tokenContextKey{}, token)
+	return WithPrincipal(ctx, NewClaimPrincipal(claim))
+}
+
+// PrincipalFromContext returns the principal of the context, or the ClaimPrincipal of the
+// claim of a context built by hand with UserClaim.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	if p, ok := ctx.Value(principalContextKey{}).(Principal); ok && p != nil {
+		return p, true
+	}
+	if claim, ok := ClaimFromContext(ctx); ok {
+		return NewClaimPrincipal(claim), true
+	}
+	return nil, false
+}
+
+// ClaimFromContext returns the claim of the token the request was authenticated with.
+func ClaimFromContext(ctx context.Context) (*security.GoClaim, bool) {
+	claim, ok := ctx.Value(UserClaim).(*security.GoClaim)
+	return claim, ok && claim != nil
+}
+
+// TokenFromContext returns the token the request was authenticated with, without the
+// "Bearer " prefix UserAuthorization carries, so it can be relayed to other services.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(string)
+	return token, ok && token != ""
+}
@@ -0,0 +1,86 @@
+package dokku_common
+
+import (
+	"context"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClaimPrincipal(t *testing.T) {
+	p := NewClaimPrincipal(&security.GoClaim{
+		Subscriber: "user@dokku",
+		TokenType:  security.AccessToken,
+		Audience:   []string{"admin,dev@acme,globex", "viewer@*", "not-a-tenant-role"},
+	})
+	assert.Equal(t, "user@dokku", p.Subject())
+	assert.Equal(t, security.AccessToken, p.TokenType())
+	assert.Equal(t, AuthMethodJWT, p.AuthMethod())
+	assert.Equal(t, []string{"acme", "globex", "*"}, p.Tenants())
+	assert.Equal(t, []string{"admin", "dev", "viewer"}, p.Roles("acme"))
+	assert.Equal(t, []string{"viewer"}, p.Roles("initech"))
+	assert.True(t, PrincipalHasRole(p, "globex", "dev"))
+	assert.True(t, PrincipalHasRole(p, "initech", "viewer"))
+	assert.False(t, PrincipalHasRole(p, "initech", "admin"))
+	assert.True(t, PrincipalHasRoles(p, "acme", []string{"owner", "dev"}, false))
+	assert.False(t, PrincipalHasRoles(p, "acme", []string{"owner", "dev"}, true))
+	assert.True(t, PrincipalHasRoles(p, "acme", []string{"admin", "viewer"}, true))
+	assert.False(t, PrincipalHasRoles(p, "acme", nil, true))
+}
+
+func TestPrincipalContext(t *testing.T) {
+	// a job acting for a service, without any HTTP request or token
+	job := &StaticPrincipal{
+		SubjectID:   "billing-job",
+		TenantRoles: map[string][]string{"acme": {"billing"}, "*": {"reader"}},
+		Method:      AuthMethodInternal,
+	}
+	ctx := WithPrincipal(context.Background(), job)
+	p, ok := PrincipalFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "billing-job", p.Subject())
+	assert.True(t, PrincipalHasRole(p, "acme", "billing"))
+	assert.True(t, PrincipalHasRole(p, "globex", "reader"))
+	assert.False(t, PrincipalHasRole(p, "globex", "billing"))
+	_, ok = ClaimFromContext(ctx)
+	assert.False(t, ok)
+	_, ok = TokenFromContext(ctx)
+	assert.False(t, ok)
+
+	// an API key principal passes the same authorization middlewares as a token
+	req := httptest.NewRequest(http.MethodGet, "/tenants/acme", nil)
+	req = req.WithContext(WithPrincipal(req.Context(), &StaticPrincipal{SubjectID: "key-1", TenantRoles: map[string][]string{"acme": {"admin"}}, Method: AuthMethodAPIKey}))
+	rec := httptest.NewRecorder()
+	RequireTenantRole("admin", TenantFromPathSegment(1))(http.NotFoundHandler()).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	_, ok = PrincipalFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestTokenFromContext(t *testing.T) {
+	signer := newTestSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	auth, err := NewAuthenticator(WithKeyResolver(verifier))
+	assert.NoError(t, err)
+	token := newTestToken(t, signer, &security.GoClaim{Subscriber: "user@dokku", Audience: []string{"admin@acme"}, ExpireAt: time.Now().Add(time.Hour)})
+
+	var relayed string
+	var principal Principal
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relayed, _ = TokenFromContext(r.Context())
+		principal, _ = PrincipalFromContext(r.Context())
+		claim, ok := ClaimFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "user@dokku", claim.Subscriber)
+		assert.Equal(t, "Bearer "+token, r.Context().Value(UserAuthorization))
+	}))
+	serveWithToken(handler, "bearer "+token)
+	assert.Equal(t, token, relayed)
+	assert.Equal(t, "user@dokku", principal.Subject())
+	assert.True(t, PrincipalHasRole(principal, "acme", "admin"))
+}
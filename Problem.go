@@ -0,0 +1,67 @@
+package dokku_common
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. An empty Type means "about:blank", the
+// problem is then described by its Status and Title alone.
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// Extensions are additional members, such as "error" for the OAuth error code.
+	// They can not override the standard members.
+	Extensions map[string]any
+}
+
+// NewProblem creates an "about:blank" problem for the status, titled after it.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// MarshalJSON writes the standard members along with the extensions.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+	if p.Type != "" {
+		members["type"] = p.Type
+	} else {
+		delete(members, "type")
+	}
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	} else {
+		delete(members, "detail")
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	} else {
+		delete(members, "instance")
+	}
+	return json.Marshal(members)
+}
+
+// WriteProblem writes the problem as application/problem+json with the problem status,
+// logged at the level of the status. Use WriteError to honour a preference for plain text.
+func WriteProblem(w http.ResponseWriter, problem *Problem) {
+	body, err := json.Marshal(problem)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logResponse(problem.Status, len(body))
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	w.Write(body)
+}
@@ -0,0 +1,30 @@
+package dokku_common
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	problem := NewProblem(http.StatusUnauthorized, "token is expired")
+	problem.Instance = "/api/users"
+	problem.Extensions = map[string]any{"error": "invalid_token", "status": 200}
+
+	rec := httptest.NewRecorder()
+	WriteProblem(rec, problem)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+
+	body := make(map[string]any)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, map[string]any{
+		"title":    "Unauthorized",
+		"status":   float64(401),
+		"detail":   "token is expired",
+		"instance": "/api/users",
+		"error":    "invalid_token",
+	}, body)
+}
@@ -0,0 +1,270 @@
+package dokku_common
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests per Period, with bursts of up to Burst requests, Requests when
+// zero. The zero RateLimit does not limit at all.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+func (rl RateLimit) unlimited() bool {
+	return rl.Requests <= 0 || rl.Period <= 0
+}
+
+func (rl RateLimit) capacity() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return float64(rl.Requests)
+}
+
+// rate is the number of requests regained per second.
+func (rl RateLimit) rate() float64 {
+	return float64(rl.Requests) / rl.Period.Seconds()
+}
+
+// RateLimitResult is the outcome of taking a request out of a bucket.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is the wait before the next request is allowed, zero if it is already.
+	RetryAfter time.Duration
+	// Reset is the wait before the bucket is full again.
+	Reset time.Duration
+}
+
+// RateLimitStore keeps the token buckets of the rate limited keys.
+type RateLimitStore interface {
+	// Take takes a request out of the bucket of the key, refilled according to the limit.
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+// InMemoryRateLimitStore is a RateLimitStore for a single process. Buckets that are full
+// again are dropped every minute. The zero value is ready to use.
+type InMemoryRateLimitStore struct {
+	mutex    sync.Mutex
+	buckets  map[string]*tokenBucket
+	prunedAt time.Time
+}
+
+// NewInMemoryRateLimitStore creates an empty InMemoryRateLimitStore.
+func NewInMemoryRateLimitStore() *InMemoryRateLimitStore {
+	return &InMemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take implements RateLimitStore.
+func (s *InMemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.prunedAt) > time.Minute {
+		for k, bucket := range s.buckets {
+			if !now.Before(bucket.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.prunedAt = now
+	}
+
+	capacity, rate := limit.capacity(), limit.rate()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		if s.buckets == nil {
+			s.buckets = make(map[string]*tokenBucket)
+		}
+		s.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*rate)
+		bucket.last = now
+	}
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - bucket.tokens) / rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = secondsDuration((capacity - bucket.tokens) / rate)
+	bucket.full = now.Add(result.Reset)
+	return result, nil
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// RateLimitKey names the bucket a request is counted in, or returns an empty string for
+// requests that are not limited.
+type RateLimitKey func(r *http.Request) string
+
+// KeyBySubject counts the requests of each authenticated subject together, and those of
+// anonymous callers by client IP.
+func KeyBySubject() RateLimitKey {
+	return func(r *http.Request) string {
+		if principal, ok := PrincipalFromContext(r.Context()); ok && principal.Subject() != "" {
+			return "sub:" + principal.Subject()
+		}
+		return KeyByClientIP()(r)
+	}
+}
+
+// KeyByTenant counts the requests the members of each tenant address to it together, and
+// the others by client IP. The tenant is only trusted for authenticated principals with a
+// role in it, so anonymous callers naming a tenant can not drain its bucket.
+func KeyByTenant(tenant TenantSource) RateLimitKey {
+	return func(r *http.Request) string {
+		principal, ok := PrincipalFromContext(r.Context())
+		if t := tenant(r); ok && t != "" && len(principal.Roles(t)) > 0 {
+			return "tenant:" + t
+		}
+		return KeyByClientIP()(r)
+	}
+}
+
+// KeyByClientIP counts requests by the IP address of the connection. Behind a reverse proxy
+// this is the proxy address, unless the proxy rewrites RemoteAddr.
+func KeyByClientIP() RateLimitKey {
+	return func(r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return "ip:" + host
+	}
+}
+
+// RateLimiter is a token bucket rate limiting middleware. It runs after the token middleware
+// so the Key can use the verified claims. Authenticated callers get the Default limit, or
+// the most generous RoleLimits of their roles, in the tenant Tenant finds or in any of their
+// tenants without Tenant. Anonymous callers get the Anonymous limit.
+// Refused requests get 429 with Retry-After, all of them get the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers.
+type RateLimiter struct {
+	// Store keeps the buckets, an in-memory store of the RateLimiter if nil.
+	Store RateLimitStore
+	// Key names the bucket of a request, KeyBySubject if nil.
+	Key        RateLimitKey
+	Default    RateLimit
+	Anonymous  RateLimit
+	RoleLimits map[string]RateLimit
+	Tenant     TenantSource
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	memory InMemoryRateLimitStore
+}
+
+// NewRateLimiter creates a RateLimiter keyed by subject, applying the limit to every caller.
+func NewRateLimiter(store RateLimitStore, limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Store:     store,
+		Key:       KeyBySubject(),
+		Default:   limit,
+		Anonymous: limit,
+	}
+}
+
+func (rl *RateLimiter) now() time.Time {
+	if rl.Now != nil {
+		return rl.Now()
+	}
+	return time.Now()
+}
+
+func (rl *RateLimiter) key(r *http.Request) string {
+	if rl.Key != nil {
+		return rl.Key(r)
+	}
+	return KeyBySubject()(r)
+}
+
+func (rl *RateLimiter) store() RateLimitStore {
+	if rl.Store != nil {
+		return rl.Store
+	}
+	return &rl.memory
+}
+
+// limit returns the limit of the caller of the request.
+func (rl *RateLimiter) limit(r *http.Request) RateLimit {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		return rl.Anonymous
+	}
+	tenants := principal.Tenants()
+	if rl.Tenant != nil {
+		tenants = []string{rl.Tenant(r)}
+	}
+	limit, found := rl.Default, false
+	for _, tenant := range tenants {
+		if tenant == "" {
+			continue
+		}
+		for _, role := range principal.Roles(tenant) {
+			roleLimit, ok := rl.RoleLimits[role]
+			if !ok {
+				continue
+			}
+			if !found || roleLimit.unlimited() || (!limit.unlimited() && roleLimit.rate() > limit.rate()) {
+				limit, found = roleLimit, true
+			}
+		}
+	}
+	return limit
+}
+
+// Middleware limits the requests before handing them to next.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := rl.limit(r)
+		key := rl.key(r)
+		if limit.unlimited() || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// each limit has its own bucket, or a key would be refilled with the capacity and
+		// rate of whichever limit it was last taken with
+		bucket := fmt.Sprintf("%s|%d/%s/%d", key, limit.Requests, limit.Period, limit.Burst)
+		result, err := rl.store().Take(bucket, limit, rl.now())
+		if err != nil {
+			// an unavailable store must not take the service down with it
+			logrus.Warnf("rate limit store failed for %s: %s", key, err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(int(limit.capacity())))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			WriteProblem(w, NewProblem(http.StatusTooManyRequests, fmt.Sprintf("rate limit of %d requests per %s exceeded, retry in %d seconds", limit.Requests, limit.Period, retryAfter)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,152 @@
+package dokku_common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInMemoryRateLimitStore(t *testing.T) {
+	store := NewInMemoryRateLimitStore()
+	limit := RateLimit{Requests: 2, Period: time.Second, Burst: 3}
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take("k", limit, now)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+	result, err := store.Take("k", limit, now)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, result.Reset)
+
+	// two requests are regained every second
+	result, _ = store.Take("k", limit, now.Add(500*time.Millisecond))
+	assert.True(t, result.Allowed)
+	result, _ = store.Take("other", limit, now)
+	assert.True(t, result.Allowed)
+
+	// full buckets are forgotten
+	store.Take("k", limit, now.Add(2*time.Minute))
+	assert.Len(t, store.buckets, 1)
+}
+
+func TestInMemoryRateLimitStore_ZeroValue(t *testing.T) {
+	store := &InMemoryRateLimitStore{}
+	limit := RateLimit{Requests: 1, Period: time.Minute}
+	now := time.Now()
+	result, err := store.Take("k", limit, now)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, _ = store.Take("k", limit, now)
+	assert.False(t, result.Allowed)
+}
+
+func TestRateLimiter_ZeroValue(t *testing.T) {
+	limiter := &RateLimiter{Default: RateLimit{Requests: 1, Period: time.Minute}}
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(remoteAddr string) int {
+		req := withClaim(httptest.NewRequest(http.MethodGet, "/", nil), "viewer@acme")
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the requests are counted by subject, whatever their address, in a store of the limiter
+	assert.Equal(t, http.StatusNoContent, serve("192.0.2.1:5000"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("192.0.2.2:5000"))
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(NewInMemoryRateLimitStore(), RateLimit{Requests: 1, Period: time.Minute})
+	limiter.Anonymous = RateLimit{Requests: 1, Period: time.Hour}
+	limiter.RoleLimits = map[string]RateLimit{"partner": {Requests: 3, Period: time.Minute}, "internal": {}}
+	limiter.Now = func() time.Time { return now }
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	user := func() *http.Request { return withClaim(httptest.NewRequest(http.MethodGet, "/", nil), "viewer@acme") }
+	rec := serve(user())
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", rec.Header().Get("RateLimit-Reset"))
+	rec = serve(user())
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+
+	// same subject, but the partner limit counts in a bucket of its own
+	now = now.Add(time.Minute)
+	partner := func() *http.Request {
+		return withClaim(httptest.NewRequest(http.MethodGet, "/", nil), "viewer,partner@acme")
+	}
+	for i := 2; i >= 0; i-- {
+		rec = serve(partner())
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "3", rec.Header().Get("RateLimit-Limit"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve(partner()).Code)
+
+	internal := withClaim(httptest.NewRequest(http.MethodGet, "/", nil), "internal@*")
+	for i := 0; i < 10; i++ {
+		rec = serve(internal)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+
+	anonymous := httptest.NewRequest(http.MethodGet, "/", nil)
+	anonymous.RemoteAddr = "192.0.2.1:5000"
+	assert.Equal(t, http.StatusNoContent, serve(anonymous).Code)
+	rec = serve(anonymous)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "3600", rec.Header().Get("Retry-After"))
+	other := httptest.NewRequest(http.MethodGet, "/", nil)
+	other.RemoteAddr = "192.0.2.2:5000"
+	assert.Equal(t, http.StatusNoContent, serve(other).Code)
+}
+
+func TestRateLimiter_ByTenant(t *testing.T) {
+	limiter := NewRateLimiter(NewInMemoryRateLimitStore(), RateLimit{Requests: 1, Period: time.Minute})
+	limiter.Key = KeyByTenant(TenantFromHeader("X-Tenant"))
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(tenant string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		req = withClaim(req, "viewer@"+tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, serve("acme"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("acme"))
+	assert.Equal(t, http.StatusOK, serve("globex"))
+
+	// anonymous callers and outsiders naming the tenant are counted by IP
+	anonymous := httptest.NewRequest(http.MethodGet, "/", nil)
+	anonymous.Header.Set("X-Tenant", "globex")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, anonymous)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	outsider := withClaim(httptest.NewRequest(http.MethodGet, "/", nil), "viewer@initech")
+	outsider.Header.Set("X-Tenant", "globex")
+	outsider.RemoteAddr = "192.0.2.9:5000"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, outsider)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
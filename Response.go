@@ -0,0 +1,136 @@
+package dokku_common
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	JSONContentType = "application/json; charset=utf-8"
+	TextContentType = "text/plain; charset=utf-8"
+)
+
+// logResponse logs the status and body length of a response, never the body which may
+// carry personal data, at error level for server errors and debug level for the others, so
+// floods of refused requests do not flood the logs.
+func logResponse(status, length int) {
+	level := logrus.DebugLevel
+	if status >= http.StatusInternalServerError {
+		level = logrus.ErrorLevel
+	}
+	if logrus.IsLevelEnabled(level) {
+		logrus.StandardLogger().Logf(level, "[%d] %d bytes", status, length)
+	}
+}
+
+// bodyAllowed tells whether a response with the status may have a body.
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// acceptQuality returns the quality the Accept header gives to the media type, from its
+// most specific matching range, 1 without Accept header and 0 when no range matches.
+func acceptQuality(accept, mediaType string) float64 {
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, accepted := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch rangeType {
+		case mediaType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		quality, specificity = q, s
+	}
+	return quality
+}
+
+// PrefersText tells whether the Accept header of the request ranks plain text above JSON,
+// JSON being the default of the response helpers.
+func PrefersText(r *http.Request) bool {
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	jsonQuality := acceptQuality(accept, "application/json")
+	if q := acceptQuality(accept, ProblemContentType); q > jsonQuality {
+		jsonQuality = q
+	}
+	return acceptQuality(accept, "text/plain") > jsonQuality
+}
+
+// WriteJSON writes the value as JSON with the status. A value that can not be encoded is
+// logged and answered with a 500 problem.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logrus.Errorf("response of type %T can not be encoded: %s", v, err.Error())
+		WriteProblem(w, NewProblem(http.StatusInternalServerError, ""))
+		return
+	}
+	logResponse(status, len(body))
+	w.Header().Set("Content-Type", JSONContentType)
+	w.WriteHeader(status)
+	if bodyAllowed(status) {
+		w.Write(body)
+	}
+}
+
+// WriteText writes the text as text/plain with the status.
+func WriteText(w http.ResponseWriter, status int, text string) {
+	logResponse(status, len(text))
+	w.Header().Set("Content-Type", TextContentType)
+	w.WriteHeader(status)
+	if bodyAllowed(status) {
+		w.Write([]byte(text))
+	}
+}
+
+// WriteError answers the request with the error as problem details, the error envelope of
+// every response helper, or in plain text when the request prefers it. The error of server
+// errors is logged, not disclosed.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	problem := NewProblem(status, "")
+	problem.Instance = r.URL.Path
+	if err != nil {
+		if status >= http.StatusInternalServerError {
+			logrus.Errorf("%s %s failed: %s", r.Method, r.URL.Path, err.Error())
+		} else {
+			problem.Detail = err.Error()
+		}
+	}
+	if PrefersText(r) {
+		WriteText(w, status, problemText(problem))
+		return
+	}
+	WriteProblem(w, problem)
+}
+
+// problemText is the plain text form of the problem, its title followed by its detail.
+func problemText(problem *Problem) string {
+	if problem.Detail == "" {
+		return problem.Title + "\n"
+	}
+	return fmt.Sprintf("%s: %s\n", problem.Title, problem.Detail)
+}
@@ -0,0 +1,98 @@
+package dokku_common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrefersText(t *testing.T) {
+	testData := []struct {
+		accept string
+		text   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/plain", true},
+		{"text/*", true},
+		{"text/plain;q=0.5, application/json", false},
+		{"text/plain, application/json;q=0.9", true},
+		{"application/problem+json;q=0.8, text/plain;q=0.5", false},
+		{"text/html, */*;q=0.1", false},
+		{"application/*;q=0.2, text/plain;q=0.3", true},
+	}
+	for _, td := range testData {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if td.accept != "" {
+			req.Header.Set("Accept", td.accept)
+		}
+		assert.Equal(t, td.text, PrefersText(req), td.accept)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteJSON(rec, http.StatusCreated, map[string]string{"app": "web"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, JSONContentType, rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"app":"web"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	WriteJSON(rec, http.StatusNoContent, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	WriteJSON(rec, http.StatusOK, make(chan int))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+}
+
+func TestWriteError(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/apps/web", nil)
+	rec := httptest.NewRecorder()
+	WriteError(rec, req, http.StatusNotFound, fmt.Errorf("app web does not exist"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+	body := make(map[string]any)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "app web does not exist", body["detail"])
+	assert.Equal(t, "/apps/web", body["instance"])
+
+	req.Header.Set("Accept", "text/plain")
+	rec = httptest.NewRecorder()
+	WriteError(rec, req, http.StatusNotFound, fmt.Errorf("app web does not exist"))
+	assert.Equal(t, TextContentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, "Not Found: app web does not exist\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	WriteError(rec, req, http.StatusInternalServerError, fmt.Errorf("database password rejected"))
+	assert.Equal(t, "Internal Server Error\n", rec.Body.String())
+}
+
+func TestWriteHttpResponse_LogLevels(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	output, level := logrus.StandardLogger().Out, logrus.GetLevel()
+	logrus.SetOutput(buffer)
+	logrus.SetLevel(logrus.InfoLevel)
+	defer func() {
+		logrus.SetOutput(output)
+		logrus.SetLevel(level)
+	}()
+
+	WriteHttpResponse(httptest.NewRecorder(), http.StatusCreated, nil, []byte("created"))
+	WriteHttpResponse(httptest.NewRecorder(), http.StatusNoContent, nil, nil)
+	WriteHttpResponse(httptest.NewRecorder(), http.StatusConflict, nil, []byte("exists"))
+	WriteHttpResponse(httptest.NewRecorder(), http.StatusTooManyRequests, nil, nil)
+	assert.Empty(t, buffer.String())
+	WriteHttpResponse(httptest.NewRecorder(), http.StatusBadGateway, nil, []byte("upstream secret"))
+	assert.Contains(t, buffer.String(), "level=error")
+	assert.Contains(t, buffer.String(), "[502] 15 bytes")
+	assert.NotContains(t, buffer.String(), "upstream secret")
+}
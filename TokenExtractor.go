@@ -0,0 +1,125 @@
+package dokku_common
+
+import (
+	"fmt"
+	"github.com/newm4n/dokku-common/security"
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor finds the token of a request. It returns an empty token and no error
+// when the request carries none.
+type TokenExtractor interface {
+	ExtractToken(r *http.Request) (string, error)
+}
+
+// HeaderTokenExtractor extracts the token of an HTTP header using the authentication
+// scheme, such as "Authorization: Bearer <token>". The scheme is case-insensitive.
+// Without Scheme, the whole header value is the token, such as "X-Api-Token: <token>".
+type HeaderTokenExtractor struct {
+	Header string
+	Scheme string
+}
+
+// BearerTokenExtractor extracts the bearer token of the Authorization header.
+var BearerTokenExtractor TokenExtractor = &HeaderTokenExtractor{Header: "Authorization", Scheme: "Bearer"}
+
+// DefaultTokenExtractor is the extractor of UserTokenContextMiddleware and the other
+// package level token middlewares, BearerTokenExtractor unless replaced, for example by
+// a TokenExtractors chain also reading a cookie.
+var DefaultTokenExtractor = BearerTokenExtractor
+
+// ExtractToken implements TokenExtractor.
+func (e *HeaderTokenExtractor) ExtractToken(r *http.Request) (string, error) {
+	value := strings.TrimSpace(r.Header.Get(e.Header))
+	if value == "" {
+		return "", nil
+	}
+	token, ok := security.ParseAuthorization(value, e.Scheme)
+	if !ok {
+		if scheme, _, _ := strings.Cut(value, " "); e.Scheme != "" && !strings.EqualFold(scheme, e.Scheme) {
+			return "", fmt.Errorf("%w: expecting the %s scheme", ErrBearerTokenInvalid, e.Scheme)
+		}
+		return "", fmt.Errorf("%w: malformed credentials in %s", ErrBearerTokenInvalid, e.Header)
+	}
+	return token, nil
+}
+
+// CookieTokenExtractor extracts the token of the cookie called Name, such as the HttpOnly
+// cookie a browser app keeps its access token in.
+type CookieTokenExtractor struct {
+	Name string
+}
+
+// ExtractToken implements TokenExtractor.
+func (e *CookieTokenExtractor) ExtractToken(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(e.Name)
+	if err != nil {
+		return "", nil
+	}
+	return strings.TrimSpace(cookie.Value), nil
+}
+
+// QueryTokenExtractor extracts the token of the query parameter called Name, "access_token"
+// when empty, as RFC 6750 section 2.3 describes. URLs end up in logs and browser history,
+// so only use it where headers can not be set, such as WebSocket handshakes.
+type QueryTokenExtractor struct {
+	Name string
+}
+
+// ExtractToken implements TokenExtractor.
+func (e *QueryTokenExtractor) ExtractToken(r *http.Request) (string, error) {
+	name := e.Name
+	if name == "" {
+		name = "access_token"
+	}
+	return strings.TrimSpace(r.URL.Query().Get(name)), nil
+}
+
+// WebSocketTokenExtractor extracts the token browsers pass in the Sec-WebSocket-Protocol
+// header of a WebSocket handshake, as the subprotocol following the Marker one, such as
+// "Sec-WebSocket-Protocol: access_token, <token>". The Marker is "access_token" when empty.
+// The server must select the marker as subprotocol in its handshake response, never the
+// token, or browsers close the connection.
+type WebSocketTokenExtractor struct {
+	Marker string
+}
+
+// ExtractToken implements TokenExtractor.
+func (e *WebSocketTokenExtractor) ExtractToken(r *http.Request) (string, error) {
+	marker := e.Marker
+	if marker == "" {
+		marker = "access_token"
+	}
+	protocols := make([]string, 0, 2)
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+	for i, protocol := range protocols {
+		if protocol != marker {
+			continue
+		}
+		if i+1 >= len(protocols) || protocols[i+1] == "" {
+			return "", fmt.Errorf("%w: no token after the %s subprotocol", ErrBearerTokenInvalid, marker)
+		}
+		return protocols[i+1], nil
+	}
+	return "", nil
+}
+
+// TokenExtractors is a chain of extractors, the token is the one of the first extractor
+// finding one. An extractor failing on malformed credentials stops the chain.
+type TokenExtractors []TokenExtractor
+
+// ExtractToken implements TokenExtractor.
+func (te TokenExtractors) ExtractToken(r *http.Request) (string, error) {
+	for _, extractor := range te {
+		token, err := extractor.ExtractToken(r)
+		if err != nil || token != "" {
+			return token, err
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,103 @@
+package dokku_common
+
+import (
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderTokenExtractor(t *testing.T) {
+	testData := []struct {
+		header string
+		token  string
+		err    bool
+	}{
+		{"", "", false},
+		{"Bearer abc.def.ghi", "abc.def.ghi", false},
+		{"bearer abc.def.ghi", "abc.def.ghi", false},
+		{"  BEARER   abc.def.ghi  ", "abc.def.ghi", false},
+		{"Bearer", "", true},
+		{"Bearer ", "", true},
+		{"Basic dXNlcjpwYXNz", "", true},
+		{"Bearer abc def", "", true},
+		{"Bearerabc.def.ghi", "", true},
+	}
+	for _, td := range testData {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", td.header)
+		token, err := BearerTokenExtractor.ExtractToken(req)
+		assert.Equal(t, td.token, token, td.header)
+		if td.err {
+			assert.ErrorIs(t, err, ErrBearerTokenInvalid, td.header)
+		} else {
+			assert.NoError(t, err, td.header)
+		}
+	}
+}
+
+func TestTokenExtractors(t *testing.T) {
+	chain := TokenExtractors{
+		BearerTokenExtractor,
+		&HeaderTokenExtractor{Header: "X-Api-Token"},
+		&CookieTokenExtractor{Name: "access"},
+		&WebSocketTokenExtractor{},
+		&QueryTokenExtractor{},
+	}
+	testData := []struct {
+		prepare func(r *http.Request)
+		token   string
+		err     bool
+	}{
+		{func(r *http.Request) {}, "", false},
+		{func(r *http.Request) { r.Header.Set("X-Api-Token", "from-header") }, "from-header", false},
+		{func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "access", Value: "from-cookie"}) }, "from-cookie", false},
+		{func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "chat, access_token, from-ws") }, "from-ws", false},
+		{func(r *http.Request) {
+			r.Header.Add("Sec-WebSocket-Protocol", "access_token")
+			r.Header.Add("Sec-WebSocket-Protocol", "from-ws")
+		}, "from-ws", false},
+		{func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "chat, access_token") }, "", true},
+		{func(r *http.Request) { r.URL.RawQuery = "access_token=from-query" }, "from-query", false},
+		{func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer from-bearer")
+			r.AddCookie(&http.Cookie{Name: "access", Value: "from-cookie"})
+		}, "from-bearer", false},
+		{func(r *http.Request) {
+			r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+			r.AddCookie(&http.Cookie{Name: "access", Value: "from-cookie"})
+		}, "", true},
+	}
+	for i, td := range testData {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		td.prepare(req)
+		token, err := chain.ExtractToken(req)
+		assert.Equal(t, td.token, token, i)
+		if td.err {
+			assert.ErrorIs(t, err, ErrBearerTokenInvalid, i)
+		} else {
+			assert.NoError(t, err, i)
+		}
+	}
+}
+
+func TestDefaultTokenExtractor(t *testing.T) {
+	DefaultTokenExtractor = TokenExtractors{BearerTokenExtractor, &CookieTokenExtractor{Name: "access"}}
+	defer func() { DefaultTokenExtractor = BearerTokenExtractor }()
+	var subject string
+	handler := UserTokenRequiredMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.Context().Value(UserClaim).(*security.GoClaim).Subscriber
+	}))
+	token, err := (&security.GoClaim{Subscriber: "subs@Issuer.com", ExpireAt: time.Now().Add(time.Hour)}).ToToken(GetPrivateKey(nil), crypto.SigningMethodRS512)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "access", Value: token})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "subs@Issuer.com", subject)
+}
@@ -0,0 +1,223 @@
+package dokku_common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/newm4n/dokku-common/security"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// exchangedTokenRefreshIn is how long before their expiration exchanged tokens are renewed.
+const exchangedTokenRefreshIn = 30 * time.Second
+
+var (
+	ErrTokenExchange = fmt.Errorf("token exchange failed")
+)
+
+// RelayedToken returns the token of the caller the context was authenticated with by the
+// token middlewares, or the bearer token of UserAuthorization for contexts built by hand.
+func RelayedToken(ctx context.Context) (string, bool) {
+	if token, ok := TokenFromContext(ctx); ok {
+		return token, true
+	}
+	authorization, _ := ctx.Value(UserAuthorization).(string)
+	return security.ParseAuthorization(authorization, "Bearer")
+}
+
+// RelayToken sets the token of the caller of ctx in the Authorization header of the outbound
+// request, and tells whether there was one. Only relay tokens to trusted services, or use a
+// RelayTransport restricted to their hosts.
+func RelayToken(ctx context.Context, out *http.Request) bool {
+	token, ok := RelayedToken(ctx)
+	if ok {
+		out.Header.Set("Authorization", "Bearer "+token)
+	}
+	return ok
+}
+
+// TokenExchanger trades the token of the caller for a token restricted to the audience,
+// so a downstream service only gets the access it needs.
+type TokenExchanger interface {
+	Exchange(ctx context.Context, subjectToken, audience string) (*Token, error)
+}
+
+// TokenExchangeClient is a TokenExchanger asking an RFC 8693 token exchange endpoint,
+// authenticating with HTTP Basic client credentials. Scope, when set, is requested for
+// every exchanged token.
+type TokenExchangeClient struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	Scope        string
+	Client       *http.Client
+}
+
+// NewTokenExchangeClient creates a client for the endpoint at url.
+func NewTokenExchangeClient(url, clientID, clientSecret string) *TokenExchangeClient {
+	return &TokenExchangeClient{
+		URL:          url,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Exchange implements TokenExchanger.
+func (c *TokenExchangeClient) Exchange(ctx context.Context, subjectToken, audience string) (*Token, error) {
+	form := url.Values{
+		"grant_type":         {GrantTypeTokenExchange},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {TokenTypeAccessToken},
+		"audience":           {audience},
+	}
+	if c.Scope != "" {
+		form.Set("scope", c.Scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.ClientID, c.ClientSecret)
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+	}{}
+	if err := json.Unmarshal(data, &body); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrTokenExchange, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s responded with status %d %s", ErrTokenExchange, c.URL, resp.StatusCode, body.Error)
+	}
+	if body.AccessToken == "" {
+		return nil, fmt.Errorf("%w: missing access_token", ErrTokenExchange)
+	}
+	token := &Token{AccessToken: body.AccessToken}
+	if body.ExpiresIn > 0 {
+		token.ExpireAt = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// RelayTransport is an http.RoundTripper forwarding the token of the caller, found in the
+// request context by RelayedToken, to the Hosts, such as "billing.internal" or
+// "*.svc.cluster.local". Requests to other hosts, and requests already carrying an
+// Authorization header, are sent as they are. With an Exchanger, the token is exchanged for
+// one whose audience is the host before it is relayed, and the exchanged tokens with an
+// expiration are cached until they are about to expire. Base is http.DefaultTransport when nil.
+type RelayTransport struct {
+	Base      http.RoundTripper
+	Hosts     []string
+	Exchanger TokenExchanger
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	mutex     sync.Mutex
+	exchanged map[string]*Token
+}
+
+// NewRelayTransport creates a RelayTransport over base relaying the token to the hosts.
+func NewRelayTransport(base http.RoundTripper, hosts ...string) *RelayTransport {
+	return &RelayTransport{Base: base, Hosts: hosts}
+}
+
+func (t *RelayTransport) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+// relays tells whether the token can be relayed to the host.
+func (t *RelayTransport) relays(host string) bool {
+	for _, allowed := range t.Hosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(strings.ToLower(host), strings.ToLower(allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange returns the cached exchanged token of the audience, or a new one.
+func (t *RelayTransport) exchange(ctx context.Context, token, audience string) (string, error) {
+	key := audience + " " + token
+	now := t.now()
+	t.mutex.Lock()
+	cached := t.exchanged[key]
+	t.mutex.Unlock()
+	if cached.valid(now, exchangedTokenRefreshIn) {
+		return cached.AccessToken, nil
+	}
+	exchanged, err := t.Exchanger.Exchange(ctx, token, audience)
+	if err != nil {
+		return "", err
+	}
+	// without expiration there is no telling when to drop it
+	if exchanged.ExpireAt.IsZero() {
+		return exchanged.AccessToken, nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.exchanged == nil {
+		t.exchanged = make(map[string]*Token)
+	}
+	for k, cached := range t.exchanged {
+		if !cached.valid(now, exchangedTokenRefreshIn) {
+			delete(t.exchanged, k)
+		}
+	}
+	t.exchanged[key] = exchanged
+	return exchanged.AccessToken, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RelayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	token, ok := RelayedToken(req.Context())
+	host := req.URL.Hostname()
+	if !ok || req.Header.Get("Authorization") != "" || !t.relays(host) {
+		return base.RoundTrip(req)
+	}
+	if t.Exchanger != nil {
+		var err error
+		if token, err = t.exchange(req.Context(), token, host); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	return base.RoundTrip(withBearerToken(req, token))
+}
@@ -0,0 +1,117 @@
+package dokku_common
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordAuthorization returns a transport recording the Authorization header it sends.
+func recordAuthorization(sent *string) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		*sent = r.Header.Get("Authorization")
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+	})
+}
+
+func TestRelayedToken(t *testing.T) {
+	_, ok := RelayedToken(context.Background())
+	assert.False(t, ok)
+
+	token, ok := RelayedToken(WithToken(context.Background(), "caller-token", &security.GoClaim{Subscriber: "user@dokku"}))
+	assert.True(t, ok)
+	assert.Equal(t, "caller-token", token)
+
+	token, ok = RelayedToken(context.WithValue(context.Background(), UserAuthorization, "bearer by-hand"))
+	assert.True(t, ok)
+	assert.Equal(t, "by-hand", token)
+
+	out := httptest.NewRequest(http.MethodGet, "http://billing.internal/", nil)
+	assert.True(t, RelayToken(WithToken(context.Background(), "caller-token", &security.GoClaim{}), out))
+	assert.Equal(t, "Bearer caller-token", out.Header.Get("Authorization"))
+}
+
+func TestRelayTransport(t *testing.T) {
+	var sent string
+	transport := NewRelayTransport(recordAuthorization(&sent), "billing.internal", "*.svc.cluster.local")
+	ctx := WithToken(context.Background(), "caller-token", &security.GoClaim{Subscriber: "user@dokku"})
+
+	testData := []struct {
+		url           string
+		authorization string
+		sent          string
+	}{
+		{"http://billing.internal/invoices", "", "Bearer caller-token"},
+		{"http://BILLING.internal:8080/invoices", "", "Bearer caller-token"},
+		{"http://users.svc.cluster.local/me", "", "Bearer caller-token"},
+		{"http://svc.cluster.local/me", "", ""},
+		{"https://api.github.com/user", "", ""},
+		{"http://billing.internal/invoices", "Bearer service-token", "Bearer service-token"},
+	}
+	for _, td := range testData {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, td.url, nil)
+		assert.NoError(t, err)
+		if td.authorization != "" {
+			req.Header.Set("Authorization", td.authorization)
+		}
+		_, err = transport.RoundTrip(req)
+		assert.NoError(t, err)
+		assert.Equal(t, td.sent, sent, td.url)
+		assert.Equal(t, td.authorization, req.Header.Get("Authorization"), "the request is not modified")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://billing.internal/", nil)
+	assert.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "", sent)
+}
+
+func TestRelayTransport_Exchange(t *testing.T) {
+	exchanges := 0
+	exchangeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		if clientID != "gateway" || secret != "secret" || r.FormValue("subject_token") != "caller-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request"}`))
+			return
+		}
+		exchanges++
+		assert.Equal(t, GrantTypeTokenExchange, r.FormValue("grant_type"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":      "scoped-for-" + r.FormValue("audience"),
+			"issued_token_type": TokenTypeAccessToken,
+			"token_type":        "Bearer",
+			"expires_in":        300,
+		})
+	}))
+	defer exchangeServer.Close()
+
+	var sent string
+	transport := NewRelayTransport(recordAuthorization(&sent), "billing.internal")
+	transport.Exchanger = NewTokenExchangeClient(exchangeServer.URL, "gateway", "secret")
+	ctx := WithToken(context.Background(), "caller-token", &security.GoClaim{Subscriber: "user@dokku"})
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://billing.internal/invoices", nil)
+		assert.NoError(t, err)
+		_, err = transport.RoundTrip(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer scoped-for-billing.internal", sent)
+	}
+	assert.Equal(t, 1, exchanges)
+
+	// the caller token is never relayed when it can not be exchanged
+	sent = ""
+	ctx = WithToken(context.Background(), "other-token", &security.GoClaim{Subscriber: "user@dokku"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://billing.internal/invoices", nil)
+	assert.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, ErrTokenExchange)
+	assert.Contains(t, err.Error(), "invalid_request")
+	assert.Equal(t, "", sent)
+}
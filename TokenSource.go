@@ -0,0 +1,240 @@
+package dokku_common
+
+import (
+	"context"
+	"fmt"
+	"github.com/newm4n/dokku-common/security"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoToken = fmt.Errorf("token source returned no token")
+)
+
+// Token is a bearer token for outbound calls. A zero ExpireAt means it does not expire.
+type Token struct {
+	AccessToken string
+	ExpireAt    time.Time
+}
+
+// valid tells whether the token is still usable at now, with the margin to spare.
+func (t *Token) valid(now time.Time, margin time.Duration) bool {
+	return t != nil && t.AccessToken != "" && (t.ExpireAt.IsZero() || now.Add(margin).Before(t.ExpireAt))
+}
+
+// TokenSource supplies the tokens a service calls other services with. Each call returns a
+// new token, TokenTransport caches them until they are about to expire.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// SelfSignedTokenSource mints its own access tokens for the Subject, signed by the Signer,
+// for services trusted to vouch for themselves.
+type SelfSignedTokenSource struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	TTL      time.Duration
+	Signer   security.SignerSource
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewSelfSignedTokenSource creates a SelfSignedTokenSource minting 5 minutes tokens.
+func NewSelfSignedTokenSource(issuer, subject string, audience []string, signer security.SignerSource) *SelfSignedTokenSource {
+	return &SelfSignedTokenSource{Issuer: issuer, Subject: subject, Audience: audience, TTL: 5 * time.Minute, Signer: signer}
+}
+
+func (s *SelfSignedTokenSource) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Token implements TokenSource.
+func (s *SelfSignedTokenSource) Token(ctx context.Context) (*Token, error) {
+	signer, err := s.Signer.Signer()
+	if err != nil {
+		return nil, err
+	}
+	tokenID, err := security.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	claim := &security.GoClaim{
+		Issuer:     s.Issuer,
+		Subscriber: s.Subject,
+		TokenType:  security.AccessToken,
+		Audience:   s.Audience,
+		NotBefore:  now,
+		IssuedAt:   now,
+		ExpireAt:   now.Add(s.TTL),
+		Tokenid:    tokenID,
+	}
+	token, err := claim.ToTokenWith(signer)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{AccessToken: token, ExpireAt: claim.ExpireAt}, nil
+}
+
+// TokenRedeemer exchanges a refresh token for a new token pair, as security.TokenIssuer does.
+type TokenRedeemer interface {
+	Redeem(refreshToken string) (*security.TokenPair, error)
+}
+
+// RefreshTokenSource gets its access tokens by redeeming a refresh token, keeping the
+// refresh token of each new pair for the next redemption, as refresh tokens are single use.
+type RefreshTokenSource struct {
+	Redeemer TokenRedeemer
+
+	mutex        sync.Mutex
+	refreshToken string
+}
+
+// NewRefreshTokenSource creates a RefreshTokenSource starting from the refresh token.
+func NewRefreshTokenSource(redeemer TokenRedeemer, refreshToken string) *RefreshTokenSource {
+	return &RefreshTokenSource{Redeemer: redeemer, refreshToken: refreshToken}
+}
+
+// Token implements TokenSource.
+func (s *RefreshTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pair, err := s.Redeemer.Redeem(s.refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	s.refreshToken = pair.RefreshToken
+	return &Token{AccessToken: pair.AccessToken, ExpireAt: pair.AccessExpireAt}, nil
+}
+
+// TokenTransport is an http.RoundTripper attaching the bearer token of the Source to the
+// outgoing requests. The token is cached and refreshed RefreshBefore its expiration, 30
+// seconds when zero, concurrent requests sharing a single refresh. A request refused with
+// 401 is retried once with a fresh token, if its body can be sent again. Base is
+// http.DefaultTransport when nil.
+type TokenTransport struct {
+	Base          http.RoundTripper
+	Source        TokenSource
+	RefreshBefore time.Duration
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	mutex sync.Mutex
+	token *Token
+	group singleflight.Group
+}
+
+// NewTokenTransport creates a TokenTransport over base attaching the tokens of the source.
+func NewTokenTransport(base http.RoundTripper, source TokenSource) *TokenTransport {
+	return &TokenTransport{Base: base, Source: source}
+}
+
+func (t *TokenTransport) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+func (t *TokenTransport) refreshBefore() time.Duration {
+	if t.RefreshBefore > 0 {
+		return t.RefreshBefore
+	}
+	return 30 * time.Second
+}
+
+// Token returns the cached token, or a fresh one when it is about to expire or is the
+// rejected one. The refresh is shared with the concurrent callers and outlives them, but each
+// caller stops waiting for it when its own ctx is done.
+func (t *TokenTransport) Token(ctx context.Context, rejected *Token) (*Token, error) {
+	t.mutex.Lock()
+	token := t.token
+	t.mutex.Unlock()
+	if token != rejected && token.valid(t.now(), t.refreshBefore()) {
+		return token, nil
+	}
+	flight := t.group.DoChan("token", func() (any, error) {
+		t.mutex.Lock()
+		token := t.token
+		t.mutex.Unlock()
+		// another flight may have refreshed it since
+		if token != rejected && token.valid(t.now(), t.refreshBefore()) {
+			return token, nil
+		}
+		// the refresh is shared, it must not fail because the first caller gave up
+		token, err := t.Source.Token(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if token == nil || token.AccessToken == "" {
+			return nil, ErrNoToken
+		}
+		t.mutex.Lock()
+		t.token = token
+		t.mutex.Unlock()
+		return token, nil
+	})
+	select {
+	case result := <-flight:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*Token), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	token, err := t.Token(req.Context(), nil)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	res, err := base.RoundTrip(withBearerToken(req, token.AccessToken))
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if err != nil || res.StatusCode != http.StatusUnauthorized || !replayable {
+		return res, err
+	}
+
+	retry := withBearerToken(req, "")
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return res, nil
+		}
+	}
+	token, err = t.Token(req.Context(), token)
+	if err != nil {
+		if retry.Body != nil {
+			retry.Body.Close()
+		}
+		return res, nil
+	}
+	res.Body.Close()
+	retry.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return base.RoundTrip(retry)
+}
+
+// withBearerToken returns a clone of the request with the token in its Authorization
+// header, as a RoundTripper must not modify the request.
+func withBearerToken(req *http.Request, token string) *http.Request {
+	clone := req.Clone(req.Context())
+	if token != "" {
+		clone.Header.Set("Authorization", "Bearer "+token)
+	}
+	return clone
+}
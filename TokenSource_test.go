@@ -0,0 +1,170 @@
+package dokku_common
+
+import (
+	"context"
+	"fmt"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTokenSource hands out "token-1", "token-2"... expiring after ttl.
+type countingTokenSource struct {
+	count int32
+	ttl   time.Duration
+	delay time.Duration
+}
+
+func (s *countingTokenSource) Token(ctx context.Context) (*Token, error) {
+	time.Sleep(s.delay)
+	n := atomic.AddInt32(&s.count, 1)
+	return &Token{AccessToken: fmt.Sprintf("token-%d", n), ExpireAt: time.Now().Add(s.ttl)}, nil
+}
+
+// blockingTokenSource hands out "token" once release is closed.
+type blockingTokenSource struct {
+	release chan struct{}
+}
+
+func (s *blockingTokenSource) Token(ctx context.Context) (*Token, error) {
+	<-s.release
+	return &Token{AccessToken: "token", ExpireAt: time.Now().Add(time.Hour)}, nil
+}
+
+func TestSelfSignedTokenSource(t *testing.T) {
+	signer := newTestSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+
+	token, err := NewSelfSignedTokenSource("billing", "svc:billing", []string{"reader@*"}, signer).Token(context.Background())
+	assert.NoError(t, err)
+	claim, err := (&security.TokenParser{Resolver: verifier}).Parse(token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "svc:billing", claim.Subscriber)
+	assert.Equal(t, "billing", claim.Issuer)
+	assert.NotEmpty(t, claim.Tokenid)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), token.ExpireAt, time.Minute)
+}
+
+func TestRefreshTokenSource(t *testing.T) {
+	signer := newTestSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	issuer := security.NewTokenIssuer("auth", signer, verifier, security.NewInMemoryRefreshTokenStore())
+	pair, err := issuer.Issue("user@dokku", nil)
+	assert.NoError(t, err)
+
+	source := NewRefreshTokenSource(issuer, pair.RefreshToken)
+	first, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	// the rotated refresh token is redeemed the next time, not the used one
+	second, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.AccessToken, second.AccessToken)
+	assert.NotEqual(t, pair.RefreshToken, source.refreshToken)
+}
+
+func TestTokenTransport_Refresh(t *testing.T) {
+	var received []string
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		received = append(received, r.Header.Get("Authorization"))
+		mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	source := &countingTokenSource{ttl: time.Minute, delay: 20 * time.Millisecond}
+	now := time.Now()
+	transport := NewTokenTransport(nil, source)
+	transport.Now = func() time.Time { return now }
+	client := &http.Client{Transport: transport}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Get(server.URL)
+			if assert.NoError(t, err) {
+				res.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.count))
+	assert.Len(t, received, 10)
+	for _, authorization := range received {
+		assert.Equal(t, "Bearer token-1", authorization)
+	}
+
+	// refreshed shortly before it expires
+	now = now.Add(40 * time.Second)
+	res, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "Bearer token-2", received[len(received)-1])
+}
+
+func TestTokenTransport_RetryUnauthorized(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	source := &countingTokenSource{ttl: time.Hour}
+	client := &http.Client{Transport: NewTokenTransport(nil, source)}
+	res, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, []string{"payload", "payload"}, bodies)
+	assert.Equal(t, int32(2), source.count)
+
+	// a request whose body can not be sent again is not retried
+	transport := NewTokenTransport(nil, &countingTokenSource{ttl: time.Hour})
+	req, err := http.NewRequest(http.MethodPost, server.URL, io.NopCloser(strings.NewReader("once")))
+	assert.NoError(t, err)
+	res, err = transport.RoundTrip(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestTokenTransport_CancelledWhileRefreshing(t *testing.T) {
+	source := &blockingTokenSource{release: make(chan struct{})}
+	transport := NewTokenTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+	}), source)
+
+	// the caller gives up at its deadline, even though the refresh hangs
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://billing.internal/", nil)
+	assert.NoError(t, err)
+	start := time.Now()
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// the shared refresh carries on for the next callers
+	close(source.release)
+	token, err := transport.Token(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+}
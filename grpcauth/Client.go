@@ -0,0 +1,58 @@
+package grpcauth
+
+import (
+	"context"
+	dokku_common "github.com/newm4n/dokku-common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenFunc returns the token to attach to an outgoing call, or an empty string to send the
+// call without token.
+type TokenFunc func(ctx context.Context) (string, error)
+
+// StaticToken always attaches the token, such as a service token.
+func StaticToken(token string) TokenFunc {
+	return func(ctx context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+// RelayToken attaches the token the current request or call was authenticated with, so a
+// service calls the next one on behalf of its own caller.
+func RelayToken() TokenFunc {
+	return func(ctx context.Context) (string, error) {
+		token, _ := dokku_common.TokenFromContext(ctx)
+		return token, nil
+	}
+}
+
+func withToken(ctx context.Context, tokens TokenFunc) (context.Context, error) {
+	token, err := tokens(ctx)
+	if err != nil || token == "" {
+		return ctx, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, AuthorizationKey, "Bearer "+token), nil
+}
+
+// UnaryClientInterceptor attaches the token of tokens to every unary call.
+func UnaryClientInterceptor(tokens TokenFunc) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := withToken(ctx, tokens)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor attaches the token of tokens to every streaming call.
+func StreamClientInterceptor(tokens TokenFunc) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := withToken(ctx, tokens)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
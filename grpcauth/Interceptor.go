@@ -0,0 +1,229 @@
+// Package grpcauth brings the token verification and tenant-role authorization of the
+// dokku_common HTTP middlewares to gRPC servers and clients.
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dokku_common "github.com/newm4n/dokku-common"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// AuthorizationKey is the metadata key carrying the bearer token, the gRPC counterpart of
+// the Authorization header.
+const AuthorizationKey = "authorization"
+
+// TenantSource finds the tenant addressed by a call, or returns an empty string. The request
+// message is nil for streaming calls, whose tenant must come from the metadata.
+type TenantSource func(ctx context.Context, req any) string
+
+// TenantFromMetadata takes the tenant from the incoming metadata key.
+func TenantFromMetadata(key string) TenantSource {
+	return func(ctx context.Context, req any) string {
+		values := metadata.ValueFromIncomingContext(ctx, key)
+		if len(values) == 0 {
+			return ""
+		}
+		return strings.TrimSpace(values[0])
+	}
+}
+
+// TenantFromMessage takes the tenant from the request message with the getter, typically
+// calling the generated getter of its tenant field.
+func TenantFromMessage(getter func(req any) string) TenantSource {
+	return func(ctx context.Context, req any) string {
+		if req == nil {
+			return ""
+		}
+		return getter(req)
+	}
+}
+
+// TenantFrom returns the tenant of the first source finding one.
+func TenantFrom(sources ...TenantSource) TenantSource {
+	return func(ctx context.Context, req any) string {
+		for _, source := range sources {
+			if tenant := source(ctx, req); tenant != "" {
+				return tenant
+			}
+		}
+		return ""
+	}
+}
+
+// MethodRule is the access rule of a method. Without Roles the method only requires a
+// valid token. With Roles, the caller must have any of them, or all of them when AllRoles
+// is set, in the tenant the Tenant source finds.
+type MethodRule struct {
+	Roles    []string
+	AllRoles bool
+	Tenant   TenantSource
+}
+
+// Interceptor verifies the bearer token of incoming calls with the Parser and puts its claim
+// in the call context, readable with dokku_common.ClaimFromContext and PrincipalFromContext.
+// Methods with a rule, keyed by full method name such as "/pkg.Service/Method", or by
+// "/pkg.Service/*" for every method of a service, require a token and enforce the rule.
+// Other methods let anonymous calls through, unless Required is set. The authentication and
+// authorization decisions are sent to Audit, or dokku_common.DefaultAuditSink when nil.
+type Interceptor struct {
+	Parser   *security.TokenParser
+	Rules    map[string]MethodRule
+	Required bool
+	Audit    dokku_common.AuditSink
+}
+
+// NewInterceptor creates an Interceptor verifying tokens with the parser.
+func NewInterceptor(parser *security.TokenParser) *Interceptor {
+	return &Interceptor{Parser: parser, Rules: make(map[string]MethodRule)}
+}
+
+// Require sets the rule of the method, which is a full method name or a service wildcard.
+func (i *Interceptor) Require(method string, rule MethodRule) *Interceptor {
+	i.Rules[method] = rule
+	return i
+}
+
+func (i *Interceptor) rule(fullMethod string) (MethodRule, bool) {
+	if rule, ok := i.Rules[fullMethod]; ok {
+		return rule, true
+	}
+	if slash := strings.LastIndex(fullMethod, "/"); slash > 0 {
+		rule, ok := i.Rules[fullMethod[:slash]+"/*"]
+		return rule, ok
+	}
+	return MethodRule{}, false
+}
+
+// Unary returns the unary server interceptor.
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := i.authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the stream server interceptor.
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.authorize(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (i *Interceptor) authorize(ctx context.Context, fullMethod string, req any) (context.Context, error) {
+	start := time.Now()
+	rule, ruled := i.rule(fullMethod)
+	token, err := bearerToken(ctx)
+	if err != nil {
+		i.audit(ctx, fullMethod, start, &dokku_common.AuditEvent{Action: dokku_common.AuditAuthentication, Decision: dokku_common.AuditDeny, Reason: err.Error()})
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if token == "" {
+		if ruled || i.Required {
+			i.audit(ctx, fullMethod, start, &dokku_common.AuditEvent{Action: dokku_common.AuditAuthentication, Decision: dokku_common.AuditDeny, Reason: dokku_common.ErrTokenMissing.Error()})
+			return nil, status.Error(codes.Unauthenticated, dokku_common.ErrTokenMissing.Error())
+		}
+		return ctx, nil
+	}
+	claim, err := i.Parser.Parse(token)
+	authn := &dokku_common.AuditEvent{Action: dokku_common.AuditAuthentication, Decision: dokku_common.AuditAllow}
+	authn.SetClaim(claim)
+	if err != nil {
+		authn.Decision, authn.Reason = dokku_common.AuditDeny, err.Error()
+	}
+	i.audit(ctx, fullMethod, start, authn)
+	if err != nil {
+		tokenErr := &security.TokenError{}
+		if !errors.As(err, &tokenErr) {
+			logrus.Errorf("authentication of %s failed: %s", fullMethod, err.Error())
+			return nil, status.Error(codes.Internal, "authentication failed")
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	ctx = dokku_common.WithToken(ctx, token, claim)
+	if !ruled || len(rule.Roles) == 0 {
+		return ctx, nil
+	}
+
+	tenant := ""
+	if rule.Tenant != nil {
+		tenant = rule.Tenant(ctx, req)
+	}
+	authz := &dokku_common.AuditEvent{Action: dokku_common.AuditAuthorization, Decision: dokku_common.AuditDeny, Tenant: tenant, Role: strings.Join(rule.Roles, ",")}
+	authz.SetClaim(claim)
+	if tenant == "" {
+		authz.Reason = fmt.Sprintf("%s: no tenant in the call", dokku_common.ErrInsufficientScope.Error())
+		i.audit(ctx, fullMethod, start, authz)
+		return nil, status.Error(codes.PermissionDenied, authz.Reason)
+	}
+	principal, _ := dokku_common.PrincipalFromContext(ctx)
+	if !dokku_common.PrincipalHasRoles(principal, tenant, rule.Roles, rule.AllRoles) {
+		authz.Reason = fmt.Sprintf("%s: roles %s in tenant %s", dokku_common.ErrInsufficientScope.Error(), strings.Join(rule.Roles, ","), tenant)
+		i.audit(ctx, fullMethod, start, authz)
+		return nil, status.Error(codes.PermissionDenied, authz.Reason)
+	}
+	authz.Decision = dokku_common.AuditAllow
+	i.audit(ctx, fullMethod, start, authz)
+	return ctx, nil
+}
+
+// audit completes the event with the call details and sends it to the audit sink.
+func (i *Interceptor) audit(ctx context.Context, fullMethod string, start time.Time, event *dokku_common.AuditEvent) {
+	sink := i.Audit
+	if sink == nil {
+		sink = dokku_common.DefaultAuditSink
+	}
+	if sink == nil {
+		return
+	}
+	now := time.Now()
+	event.Time = now
+	event.Latency = now.Sub(start)
+	event.Route = fullMethod
+	if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(dokku_common.RequestIDHeader)); len(values) > 0 {
+		event.RequestID = values[0]
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		event.RemoteAddr = p.Addr.String()
+	}
+	sink.Audit(event)
+}
+
+// bearerToken returns the token of the authorization metadata, with a case-insensitive
+// Bearer scheme.
+func bearerToken(ctx context.Context) (string, error) {
+	values := metadata.ValueFromIncomingContext(ctx, AuthorizationKey)
+	if len(values) == 0 {
+		return "", nil
+	}
+	token, ok := security.ParseAuthorization(values[0], "Bearer")
+	if !ok {
+		return "", dokku_common.ErrBearerTokenInvalid
+	}
+	return token, nil
+}
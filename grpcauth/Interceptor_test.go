@@ -0,0 +1,197 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	dokku_common "github.com/newm4n/dokku-common"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	checkMethod = "/grpc.health.v1.Health/Check"
+	watchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+type testService struct {
+	signer   *security.Signer
+	listener *bufconn.Listener
+	subjects chan string
+}
+
+// newTestService serves the health service behind the interceptor, recording the subject
+// of the claim each call carries.
+func newTestService(t *testing.T, configure func(*Interceptor)) *testService {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := security.NewSigner(security.SigningMethodES256, ecKey)
+	assert.NoError(t, err)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+
+	ts := &testService{signer: signer, listener: bufconn.Listen(1 << 20), subjects: make(chan string, 10)}
+	interceptor := NewInterceptor(&security.TokenParser{Resolver: verifier})
+	configure(interceptor)
+	record := func(ctx context.Context) {
+		subject := ""
+		if claim, ok := dokku_common.ClaimFromContext(ctx); ok {
+			subject = claim.Subscriber
+		}
+		ts.subjects <- subject
+	}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(interceptor.Unary(), func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			record(ctx)
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(interceptor.Stream(), func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			record(ss.Context())
+			return nil
+		}),
+	)
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(ts.listener)
+	t.Cleanup(server.Stop)
+	return ts
+}
+
+func (ts *testService) client(t *testing.T, tokens TokenFunc) grpc_health_v1.HealthClient {
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ts.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(tokens)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(tokens)),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func (ts *testService) token(t *testing.T, audience ...string) string {
+	token, err := (&security.GoClaim{Subscriber: "user@dokku", Audience: audience, ExpireAt: time.Now().Add(time.Hour)}).ToTokenWith(ts.signer)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestInterceptor_Unary(t *testing.T) {
+	ts := newTestService(t, func(i *Interceptor) {
+		i.Require(checkMethod, MethodRule{Roles: []string{"admin", "ops"}, Tenant: TenantFromMetadata("x-tenant")})
+	})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme")
+
+	_, err := ts.client(t, StaticToken(ts.token(t, "ops@acme"))).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "user@dokku", <-ts.subjects)
+
+	testData := []struct {
+		ctx    context.Context
+		tokens TokenFunc
+		code   codes.Code
+	}{
+		{ctx, StaticToken(""), codes.Unauthenticated},
+		{ctx, StaticToken(ts.token(t, "ops@acme") + "x"), codes.Unauthenticated},
+		{ctx, StaticToken(ts.token(t, "viewer@acme")), codes.PermissionDenied},
+		{ctx, StaticToken(ts.token(t, "admin@globex")), codes.PermissionDenied},
+		{context.Background(), StaticToken(ts.token(t, "admin@acme")), codes.PermissionDenied},
+	}
+	for _, td := range testData {
+		_, err := ts.client(t, td.tokens).Check(td.ctx, &grpc_health_v1.HealthCheckRequest{})
+		assert.Equal(t, td.code, status.Code(err), err)
+	}
+	assert.Len(t, ts.subjects, 0)
+
+	basic := metadata.AppendToOutgoingContext(ctx, AuthorizationKey, "Basic dXNlcjpwYXNz")
+	_, err = ts.client(t, StaticToken("")).Check(basic, &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestInterceptor_Stream(t *testing.T) {
+	ts := newTestService(t, func(i *Interceptor) {
+		i.Require("/grpc.health.v1.Health/*", MethodRule{Roles: []string{"admin", "ops"}, AllRoles: true, Tenant: TenantFromMetadata("x-tenant")})
+	})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme")
+
+	watch := func(tokens TokenFunc) error {
+		stream, err := ts.client(t, tokens).Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+	assert.Equal(t, codes.PermissionDenied, status.Code(watch(StaticToken(ts.token(t, "ops@acme")))))
+	assert.Equal(t, codes.Unauthenticated, status.Code(watch(StaticToken(""))))
+	// the recording interceptor ends the stream once it saw the claim
+	err := watch(StaticToken(ts.token(t, "admin,ops@acme")))
+	assert.NotEqual(t, codes.PermissionDenied, status.Code(err))
+	assert.NotEqual(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, "user@dokku", <-ts.subjects)
+}
+
+func TestInterceptor_Optional(t *testing.T) {
+	ts := newTestService(t, func(i *Interceptor) {})
+	_, err := ts.client(t, StaticToken("")).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", <-ts.subjects)
+
+	// a service relays the token of its own caller
+	token := ts.token(t)
+	caller := dokku_common.WithToken(context.Background(), token, &security.GoClaim{Subscriber: "user@dokku"})
+	_, err = ts.client(t, RelayToken()).Check(caller, &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "user@dokku", <-ts.subjects)
+
+	required := newTestService(t, func(i *Interceptor) { i.Required = true })
+	_, err = required.client(t, StaticToken("")).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+type channelAuditSink chan *dokku_common.AuditEvent
+
+func (s channelAuditSink) Audit(event *dokku_common.AuditEvent) {
+	s <- event
+}
+
+func TestInterceptor_Audit(t *testing.T) {
+	sink := make(channelAuditSink, 10)
+	ts := newTestService(t, func(i *Interceptor) {
+		i.Audit = sink
+		i.Require(checkMethod, MethodRule{Roles: []string{"admin"}, Tenant: TenantFromMetadata("x-tenant")})
+	})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme", "x-request-id", "req-1")
+
+	_, err := ts.client(t, StaticToken(ts.token(t, "viewer@acme"))).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	authn, authz := <-sink, <-sink
+	assert.Equal(t, dokku_common.AuditAuthentication, authn.Action)
+	assert.Equal(t, dokku_common.AuditAllow, authn.Decision)
+	assert.Equal(t, "user@dokku", authn.Subject)
+	assert.Equal(t, checkMethod, authn.Route)
+	assert.Equal(t, "req-1", authn.RequestID)
+	assert.NotEmpty(t, authn.RemoteAddr)
+	assert.Equal(t, dokku_common.AuditAuthorization, authz.Action)
+	assert.Equal(t, dokku_common.AuditDeny, authz.Decision)
+	assert.Equal(t, "acme", authz.Tenant)
+	assert.Equal(t, "admin", authz.Role)
+
+	_, err = ts.client(t, StaticToken(ts.token(t, "admin@acme"))).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	<-ts.subjects
+	<-sink
+	assert.Equal(t, dokku_common.AuditAllow, (<-sink).Decision)
+}
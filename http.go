@@ -1,13 +1,14 @@
 package dokku_common
 
 import (
-	"context"
 	"crypto/rsa"
 	"fmt"
 	"github.com/SermoDigital/jose/crypto"
 	"github.com/newm4n/dokku-common/security"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type ContextKey string
@@ -60,64 +61,69 @@ Gr1F29l4A1wGjQt0oA3eTATFng+jD0MLmR3lP7elATNTmHawpBH6IqWX9eKVSJ8M
 -----END PUBLIC KEY-----`
 )
 
+// publicKeyVerifier is the RS512 verifier of the GetPublicKey key, which is only read once.
+var publicKeyVerifier = sync.OnceValue(func() *security.Verifier {
+	return &security.Verifier{Method: crypto.SigningMethodRS512, Key: GetPublicKey(nil)}
+})
+
+// defaultKeyResolver returns security.DefaultKeyResolver, or the publicKeyVerifier when unset.
+func defaultKeyResolver() security.KeyResolver {
+	if security.DefaultKeyResolver != nil {
+		return security.DefaultKeyResolver
+	}
+	return publicKeyVerifier()
+}
+
+// RequestMayThrough tells whether the principal of the request, such as the claim put in
+// the context by the token middlewares, has the role in the tenant. The decision is sent to
+// DefaultAuditSink.
 func RequestMayThrough(request *http.Request, tenant, role string) bool {
-	if request == nil || len(tenant) == 0 || len(role) == 0 {
+	if request == nil {
 		return false
 	}
-	if goClaim, ok := request.Context().Value(UserClaim).(*security.GoClaim); ok {
-		for _, aud := range goClaim.Audience {
-			tr, err := security.NewTenantRole(aud)
-			if err != nil {
-				logrus.Debugf("error while creating tenant-role got %s", err.Error())
-				continue
-			}
-			if tr.Validates(tenant, role) {
-				return true
-			}
-		}
+	start := time.Now()
+	event := &AuditEvent{Action: AuditAuthorization, Decision: AuditDeny, Tenant: tenant, Role: role}
+	principal, ok := PrincipalFromContext(request.Context())
+	granted := ok && PrincipalHasRole(principal, tenant, role)
+	switch {
+	case granted:
+		event.Decision = AuditAllow
+	case !ok:
+		event.Reason = ErrTokenMissing.Error()
+	default:
+		event.Reason = fmt.Sprintf("%s: role %s in tenant %s", ErrInsufficientScope.Error(), role, tenant)
 	}
-	return false
+	event.SetPrincipal(principal)
+	auditRequest(DefaultAuditSink, request, start, event)
+	return granted
 }
 
+// UserTokenContextMiddleware verifies the token DefaultTokenExtractor finds, the bearer token
+// of the Authorization header unless replaced, with security.DefaultKeyResolver, or the RS512
+// public key of GetPublicKey when unset, checks its claims against the
+// security.DefaultValidationPolicy and puts its claim in the request context under UserClaim.
+// Requests without token are let through anonymously, use UserTokenRequiredMiddleware to
+// refuse them.
 func UserTokenContextMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		AuthHeader := r.Header.Get("Authorization")
-		if len(AuthHeader) > 0 {
-			if len(AuthHeader) < 7 {
-				w.Header().Add("Content-Type", "text/plain")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("Authorization header found, but it seems that it uses wrong bearer string"))
-				return
-			}
-			if GetPublicKey(nil) == nil {
-				w.Header().Add("Content-Type", "text/plain")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("Authorization header found, but public key for verification is not configured"))
-				return
-			}
-			goClaim, err := security.NewGoClaimFromToken(AuthHeader[7:], GetPublicKey(nil), crypto.SigningMethodRS512)
-			if err != nil {
-				w.Header().Add("Content-Type", "text/plain")
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte(fmt.Sprintf("Authorization header found, but token contains problem. %s", err.Error())))
-				return
-			}
-			nCtx := context.WithValue(r.Context(), UserAuthorization, AuthHeader)
-			nCtx = context.WithValue(nCtx, UserClaim, goClaim)
-			next.ServeHTTP(w, r.WithContext(nCtx))
-		}
-		next.ServeHTTP(w, r)
-	})
+	return userTokenMiddleware(next, false)
+}
+
+// UserTokenRequiredMiddleware works like UserTokenContextMiddleware, but refuses requests
+// without token with 401.
+func UserTokenRequiredMiddleware(next http.Handler) http.Handler {
+	return userTokenMiddleware(next, true)
 }
 
+func userTokenMiddleware(next http.Handler, required bool) http.Handler {
+	// without parser nor extractor, the package defaults are used at each request
+	authenticator := &Authenticator{errorWriter: ProblemAuthErrorWriter, required: required}
+	return authenticator.Middleware(next)
+}
+
+// WriteHttpResponse writes the raw body with the headers and status, logged at the level of
+// the status. WriteJSON and WriteError write the uniform payloads of the services.
 func WriteHttpResponse(response http.ResponseWriter, status int, headers map[string][]string, body []byte) {
-	if status != http.StatusOK {
-		if body == nil {
-			logrus.Warnf("[%d]", status)
-		} else {
-			logrus.Warnf("[%d] %s", status, string(body))
-		}
-	}
+	logResponse(status, len(body))
 	if headers != nil {
 		for headerKey, headerValueArray := range headers {
 			for _, headerValue := range headerValueArray {
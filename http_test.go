@@ -1,15 +1,84 @@
 package dokku_common
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/newm4n/dokku-common/security"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Test_RequestMayThrough(t *testing.T) {
-	// todo Finish this test
+	sink := &recordingAuditSink{}
+	DefaultAuditSink = sink
+	defer func() { DefaultAuditSink = nil }()
+
+	req := withClaim(httptest.NewRequest(http.MethodGet, "/apps", nil), "admin@acme")
+	assert.True(t, RequestMayThrough(req, "acme", "admin"))
+	assert.False(t, RequestMayThrough(req, "globex", "admin"))
+	assert.False(t, RequestMayThrough(httptest.NewRequest(http.MethodGet, "/apps", nil), "acme", "admin"))
+	assert.False(t, RequestMayThrough(nil, "acme", "admin"))
+
+	events := sink.reset()
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, AuditAllow, events[0].Decision)
+		assert.Equal(t, AuditAuthorization, events[0].Action)
+		assert.Equal(t, "acme", events[0].Tenant)
+		assert.Equal(t, "admin", events[0].Role)
+		assert.Equal(t, "GET /apps", events[0].Route)
+		assert.Equal(t, AuditDeny, events[1].Decision)
+		assert.Contains(t, events[1].Reason, ErrInsufficientScope.Error())
+		assert.Equal(t, AuditDeny, events[2].Decision)
+		assert.Equal(t, ErrTokenMissing.Error(), events[2].Reason)
+	}
 }
 func Test_UserTokenContextMiddleware(t *testing.T) {
-	// todo Finish this test
+	calls := 0
+	var subject string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		subject = ""
+		if gc, ok := r.Context().Value(UserClaim).(*security.GoClaim); ok {
+			subject = gc.Subscriber
+		}
+	})
+	permissive, strict := UserTokenContextMiddleware(next), UserTokenRequiredMiddleware(next)
+	token, err := (&security.GoClaim{Subscriber: "subs@Issuer.com", ExpireAt: time.Now().Add(time.Hour)}).ToToken(GetPrivateKey(nil), crypto.SigningMethodRS512)
+	assert.NoError(t, err)
+
+	testData := []struct {
+		handler http.Handler
+		header  string
+		status  int
+		calls   int
+		subject string
+	}{
+		{permissive, "Bearer " + token, http.StatusOK, 1, "subs@Issuer.com"},
+		{permissive, "bearer  " + token, http.StatusOK, 1, "subs@Issuer.com"},
+		{permissive, "", http.StatusOK, 1, ""},
+		{permissive, "Basic " + token, http.StatusBadRequest, 0, ""},
+		{permissive, "Bearer", http.StatusBadRequest, 0, ""},
+		{permissive, "Bearer " + token + "x", http.StatusUnauthorized, 0, ""},
+		{strict, "BEARER " + token, http.StatusOK, 1, "subs@Issuer.com"},
+		{strict, "", http.StatusUnauthorized, 0, ""},
+	}
+	for _, td := range testData {
+		calls, subject = 0, ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if td.header != "" {
+			req.Header.Set("Authorization", td.header)
+		}
+		rec := httptest.NewRecorder()
+		td.handler.ServeHTTP(rec, req)
+		assert.Equal(t, td.status, rec.Code, td.header)
+		assert.Equal(t, td.calls, calls, td.header)
+		assert.Equal(t, td.subject, subject, td.header)
+	}
 }
 func Test_KeyLoadEmpty(t *testing.T) {
 	privateKey := GetPrivateKey(nil)
@@ -24,3 +93,113 @@ func Test_KeyLoadLoaded(t *testing.T) {
 	publicKey := GetPublicKey([]byte(DefaultPublicPEM))
 	assert.NotNil(t, publicKey)
 }
+
+func Test_UserTokenContextMiddleware_KeyResolver(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := security.NewSigner(security.SigningMethodES256, ecKey)
+	assert.NoError(t, err)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+
+	token, err := (&security.GoClaim{Subscriber: "subs@Issuer.com", ExpireAt: time.Now().Add(time.Hour)}).ToTokenWith(signer)
+	assert.NoError(t, err)
+
+	security.DefaultKeyResolver = verifier
+	defer func() { security.DefaultKeyResolver = nil }()
+	calls := 0
+	handler := UserTokenContextMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if goClaim, ok := r.Context().Value(UserClaim).(*security.GoClaim); ok {
+			w.Write([]byte(goClaim.Subscriber))
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "subs@Issuer.com", rec.Body.String())
+	assert.Equal(t, 1, calls)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token+"x")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, 1, calls)
+}
+
+func Test_UserTokenContextMiddleware_Policy(t *testing.T) {
+	security.DefaultValidationPolicy = &security.ValidationPolicy{Issuers: []string{"https://auth.dokku.me"}, RequiredClaims: []string{"sub"}}
+	defer func() { security.DefaultValidationPolicy = nil }()
+	handler := UserTokenContextMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	testData := []struct {
+		claim  *security.GoClaim
+		status int
+	}{
+		{&security.GoClaim{Issuer: "https://auth.dokku.me", Subscriber: "subs@Issuer.com"}, http.StatusNoContent},
+		{&security.GoClaim{Issuer: "https://auth.dokku.me"}, http.StatusUnauthorized},
+		{&security.GoClaim{Issuer: "https://evil.example", Subscriber: "subs@Issuer.com"}, http.StatusUnauthorized},
+	}
+	for _, td := range testData {
+		token, err := td.claim.ToToken(GetPrivateKey(nil), crypto.SigningMethodRS512)
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, td.status, rec.Code)
+	}
+}
+
+func Test_UserTokenContextMiddleware_Revoked(t *testing.T) {
+	store := security.NewInMemoryRevocationStore()
+	security.DefaultRevocationStore = store
+	defer func() { security.DefaultRevocationStore = nil }()
+	handler := UserTokenContextMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	claim := &security.GoClaim{Subscriber: "subs@Issuer.com", Tokenid: "jti-1", ExpireAt: time.Now().Add(time.Hour)}
+	token, err := claim.ToToken(GetPrivateKey(nil), crypto.SigningMethodRS512)
+	assert.NoError(t, err)
+	assert.NoError(t, security.RevokeGoClaim(store, claim))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), security.ErrTokenRevoked.Error())
+}
+
+func Test_UserTokenContextMiddleware_Encrypted(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	decrypter, err := security.NewDecrypter(ecKey)
+	assert.NoError(t, err)
+	encrypter, err := decrypter.Encrypter()
+	assert.NoError(t, err)
+	security.DefaultDecrypter = decrypter
+	defer func() { security.DefaultDecrypter = nil }()
+
+	var subject string
+	handler := UserTokenContextMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gc, ok := r.Context().Value(UserClaim).(*security.GoClaim); ok {
+			subject = gc.Subscriber
+		}
+	}))
+	claim := &security.GoClaim{Subscriber: "subs@Issuer.com", ExpireAt: time.Now().Add(time.Hour)}
+	token, err := claim.ToEncryptedToken(&security.Signer{Method: crypto.SigningMethodRS512, Key: GetPrivateKey(nil)}, encrypter)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "subs@Issuer.com", subject)
+}
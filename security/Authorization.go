@@ -0,0 +1,24 @@
+package security
+
+import (
+	"strings"
+)
+
+// ParseAuthorization returns the credentials of an Authorization header value using the
+// scheme, compared case-insensitively, such as the token of "Bearer <token>". Without
+// scheme, the whole value is the credentials. It returns false when the scheme differs,
+// or when the credentials are empty or contain spaces.
+func ParseAuthorization(value, scheme string) (string, bool) {
+	credentials := strings.TrimSpace(value)
+	if scheme != "" {
+		given, rest, found := strings.Cut(credentials, " ")
+		if !found || !strings.EqualFold(given, scheme) {
+			return "", false
+		}
+		credentials = strings.TrimSpace(rest)
+	}
+	if credentials == "" || strings.ContainsAny(credentials, " \t") {
+		return "", false
+	}
+	return credentials, true
+}
@@ -0,0 +1,29 @@
+package security
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseAuthorization(t *testing.T) {
+	testData := []struct {
+		value       string
+		scheme      string
+		credentials string
+		ok          bool
+	}{
+		{"Bearer abc", "Bearer", "abc", true},
+		{" bearer   abc ", "Bearer", "abc", true},
+		{"Basic abc", "Bearer", "", false},
+		{"Bearer", "Bearer", "", false},
+		{"Bearer a b", "Bearer", "", false},
+		{"abc", "", "abc", true},
+		{"a\tbc", "", "", false},
+		{"", "", "", false},
+	}
+	for _, td := range testData {
+		credentials, ok := ParseAuthorization(td.value, td.scheme)
+		assert.Equal(t, td.credentials, credentials, td.value)
+		assert.Equal(t, td.ok, ok, td.value)
+	}
+}
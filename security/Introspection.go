@@ -0,0 +1,297 @@
+package security
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrClientUnauthorized    = fmt.Errorf("introspection client is not authorized")
+	ErrIntrospectionResponse = fmt.Errorf("invalid introspection response")
+)
+
+// ClientAuthenticator authenticates the protected resources calling the IntrospectionHandler.
+type ClientAuthenticator interface {
+	// AuthenticateClient returns the ID of the client making the request, or false if the
+	// request carries no valid client credentials.
+	AuthenticateClient(r *http.Request) (string, bool)
+}
+
+// ClientSecrets maps client IDs to their secret. The credentials are taken from the HTTP
+// Basic authorization header, or from the client_id and client_secret form parameters.
+type ClientSecrets map[string]string
+
+// AuthenticateClient implements ClientAuthenticator. The secret is compared in constant
+// time, against a dummy one for unknown clients, so the time taken tells nothing about the
+// client IDs or their secrets.
+func (cs ClientSecrets) AuthenticateClient(r *http.Request) (string, bool) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	expected, known := cs[id]
+	if !known {
+		expected = "unknown client"
+	}
+	given, wanted := sha256.Sum256([]byte(secret)), sha256.Sum256([]byte(expected))
+	matches := subtle.ConstantTimeCompare(given[:], wanted[:]) == 1
+	if id == "" || !known || !matches {
+		return "", false
+	}
+	return id, true
+}
+
+// IntrospectionHandler is the RFC 7662 token introspection endpoint, for the components
+// that can not verify tokens themselves. The token is verified and validated by the
+// Parser, including its Revocations store, and is reported inactive if it is refused.
+// Only clients authenticated by Clients may introspect tokens.
+type IntrospectionHandler struct {
+	Parser  *TokenParser
+	Clients ClientAuthenticator
+}
+
+// NewIntrospectionHandler creates the endpoint for the parser and clients. The parser must
+// have a Resolver.
+func NewIntrospectionHandler(parser *TokenParser, clients ClientAuthenticator) (*IntrospectionHandler, error) {
+	if parser == nil || parser.Resolver == nil {
+		return nil, ErrNoVerifier
+	}
+	return &IntrospectionHandler{Parser: parser, Clients: clients}, nil
+}
+
+func (h *IntrospectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := r.ParseForm(); err != nil {
+		writeIntrospection(w, http.StatusBadRequest, map[string]any{"error": "invalid_request"})
+		return
+	}
+	if h.Clients == nil {
+		writeIntrospectionUnauthorized(w)
+		return
+	}
+	if _, ok := h.Clients.AuthenticateClient(r); !ok {
+		writeIntrospectionUnauthorized(w)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeIntrospection(w, http.StatusBadRequest, map[string]any{"error": "invalid_request"})
+		return
+	}
+	if h.Parser == nil {
+		logrus.Errorf("token introspection failed: %s", ErrNoVerifier.Error())
+		writeIntrospection(w, http.StatusInternalServerError, map[string]any{"error": "server_error"})
+		return
+	}
+	gc, err := h.Parser.Parse(token)
+	if err != nil {
+		tokenErr := &TokenError{}
+		if !errors.As(err, &tokenErr) {
+			logrus.Warnf("token introspection failed: %s", err.Error())
+			writeIntrospection(w, http.StatusInternalServerError, map[string]any{"error": "server_error"})
+			return
+		}
+		writeIntrospection(w, http.StatusOK, map[string]any{"active": false})
+		return
+	}
+	writeIntrospection(w, http.StatusOK, introspectionFromGoClaim(gc))
+}
+
+func writeIntrospectionUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="introspection"`)
+	writeIntrospection(w, http.StatusUnauthorized, map[string]any{"error": "invalid_client"})
+}
+
+func writeIntrospection(w http.ResponseWriter, status int, body map[string]any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// introspectionFromGoClaim renders an active token with its claims. Custom claims come
+// first so they can not shadow the registered ones.
+func introspectionFromGoClaim(gc *GoClaim) map[string]any {
+	ret := make(map[string]any, len(gc.Custom)+9)
+	for name, value := range gc.Custom {
+		ret[name] = value
+	}
+	ret["active"] = true
+	if gc.Issuer != "" {
+		ret["iss"] = gc.Issuer
+	}
+	if gc.Subscriber != "" {
+		ret["sub"] = gc.Subscriber
+	}
+	if len(gc.Audience) > 0 {
+		ret["aud"] = gc.Audience
+	}
+	if gc.TokenType != "" {
+		ret["typ"] = gc.TokenType
+	}
+	if gc.Tokenid != "" {
+		ret["jti"] = gc.Tokenid
+	}
+	if !gc.NotBefore.IsZero() {
+		ret["nbf"] = gc.NotBefore.Unix()
+	}
+	if !gc.IssuedAt.IsZero() {
+		ret["iat"] = gc.IssuedAt.Unix()
+	}
+	if !gc.ExpireAt.IsZero() {
+		ret["exp"] = gc.ExpireAt.Unix()
+	}
+	return ret
+}
+
+// goClaimFromIntrospection is the reverse of introspectionFromGoClaim. The RFC 7662
+// members that are not claims, such as "active" or "token_type", are dropped.
+func goClaimFromIntrospection(body map[string]any) (*GoClaim, error) {
+	gc := &GoClaim{}
+	for name, value := range body {
+		var ok bool
+		switch name {
+		case "active", "token_type":
+			ok = true
+		case "iss":
+			gc.Issuer, ok = value.(string)
+		case "sub":
+			gc.Subscriber, ok = value.(string)
+		case "jti":
+			gc.Tokenid, ok = value.(string)
+		case "typ":
+			var typ string
+			if typ, ok = value.(string); ok {
+				gc.TokenType = AccessToken
+				if typ == string(RefreshToken) {
+					gc.TokenType = RefreshToken
+				}
+			}
+		case "aud":
+			gc.Audience, ok = audienceOf(value)
+		case "nbf":
+			gc.NotBefore, ok = unixTimeOf(value)
+		case "iat":
+			gc.IssuedAt, ok = unixTimeOf(value)
+		case "exp":
+			gc.ExpireAt, ok = unixTimeOf(value)
+		default:
+			gc.SetClaim(name, value)
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: claim %s", ErrIntrospectionResponse, name)
+		}
+	}
+	return gc, nil
+}
+
+func audienceOf(value any) ([]string, bool) {
+	switch aud := value.(type) {
+	case string:
+		return []string{aud}, true
+	case []any:
+		ret := make([]string, 0, len(aud))
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return nil, false
+			}
+			ret = append(ret, s)
+		}
+		return ret, true
+	}
+	return nil, false
+}
+
+func unixTimeOf(value any) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// IntrospectionClient asks an RFC 7662 introspection endpoint, such as an
+// IntrospectionHandler, whether a token is active, authenticating with HTTP Basic
+// client credentials.
+type IntrospectionClient struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+}
+
+// NewIntrospectionClient creates a client for the endpoint at url.
+func NewIntrospectionClient(url, clientID, clientSecret string) *IntrospectionClient {
+	return &IntrospectionClient{
+		URL:          url,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Introspect returns the claims of the token if the endpoint reports it active. An inactive
+// token is refused with a *TokenError wrapping ErrTokenInactive.
+func (c *IntrospectionClient) Introspect(ctx context.Context, token string) (*GoClaim, error) {
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.ClientID, c.ClientSecret)
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: %s", ErrClientUnauthorized, c.ClientID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection %s responded with status %d", c.URL, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	body := make(map[string]any)
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIntrospectionResponse, err.Error())
+	}
+	active, ok := body["active"].(bool)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing active", ErrIntrospectionResponse)
+	}
+	if !active {
+		return nil, &TokenError{Err: ErrTokenInactive}
+	}
+	return goClaimFromIntrospection(body)
+}
@@ -0,0 +1,107 @@
+package security
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntrospection(t *testing.T) {
+	signer := newTestECSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	revocations := NewInMemoryRevocationStore()
+	parser := &TokenParser{Resolver: verifier, Revocations: revocations}
+	handler, err := NewIntrospectionHandler(parser, ClientSecrets{"legacy": "s3cret"})
+	assert.NoError(t, err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := NewIntrospectionClient(server.URL, "legacy", "s3cret")
+
+	now := time.Now().Truncate(time.Second)
+	claim := &GoClaim{
+		Issuer:     "https://auth.dokku.me",
+		Subscriber: "subs@Issuer.com",
+		TokenType:  AccessToken,
+		Audience:   []string{"tenant@role"},
+		IssuedAt:   now,
+		ExpireAt:   now.Add(time.Hour),
+		Tokenid:    "jti-1",
+	}
+	claim.SetClaim("email", "subs@issuer.com")
+	token, err := claim.ToTokenWith(signer)
+	assert.NoError(t, err)
+
+	gc, err := client.Introspect(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, claim, gc)
+
+	assert.NoError(t, RevokeGoClaim(revocations, claim))
+	_, err = client.Introspect(context.Background(), token)
+	assert.ErrorIs(t, err, ErrTokenInactive)
+
+	_, err = client.Introspect(context.Background(), "not-a-token")
+	assert.ErrorIs(t, err, ErrTokenInactive)
+
+	_, err = NewIntrospectionClient(server.URL, "legacy", "wrong").Introspect(context.Background(), token)
+	assert.ErrorIs(t, err, ErrClientUnauthorized)
+}
+
+func TestIntrospectionHandler_Requests(t *testing.T) {
+	_, err := NewIntrospectionHandler(nil, ClientSecrets{"legacy": "s3cret"})
+	assert.ErrorIs(t, err, ErrNoVerifier)
+	_, err = NewIntrospectionHandler(&TokenParser{}, ClientSecrets{"legacy": "s3cret"})
+	assert.ErrorIs(t, err, ErrNoVerifier)
+	handler := &IntrospectionHandler{Parser: &TokenParser{}, Clients: ClientSecrets{"legacy": "s3cret"}}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/introspect", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// client_secret_post credentials, but no token
+	form := url.Values{"client_id": {"legacy"}, "client_secret": {"s3cret"}}
+	req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	form = url.Values{"token": {"abc"}}
+	req = httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Basic realm="introspection"`, rec.Header().Get("WWW-Authenticate"))
+
+	// a parser without resolver is a server fault, not an inactive token
+	req = httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("legacy", "s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// nor is a handler without parser
+	handler.Parser = nil
+	req = httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("legacy", "s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// unknown clients are refused like wrong secrets
+	for _, credentials := range [][2]string{{"legacy", "wrong"}, {"unknown", "s3cret"}, {"", ""}} {
+		req = httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(credentials[0], credentials[1])
+		_, ok := ClientSecrets{"legacy": "s3cret"}.AuthenticateClient(req)
+		assert.False(t, ok, credentials[0])
+	}
+}
@@ -0,0 +1,356 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Key management algorithms and content encryption supported for JWE tokens, RFC 7516.
+const (
+	KeyAlgRSAOAEP256 = "RSA-OAEP-256"
+	KeyAlgECDHES     = "ECDH-ES"
+	EncA256GCM       = "A256GCM"
+)
+
+var (
+	ErrUnsupportedEncryption = fmt.Errorf("unsupported token encryption")
+	ErrNoDecrypter           = fmt.Errorf("no decrypter for the encrypted token")
+)
+
+// DefaultDecrypter, when set, lets NewGoClaimFromToken and NewGoClaimFromTokenWith, and so
+// the UserTokenContextMiddleware, accept encrypted tokens as well as signed ones.
+var DefaultDecrypter *Decrypter
+
+// Encrypter encrypts tokens for the holder of the matching Decrypter. The key is an
+// *rsa.PublicKey for RSA-OAEP-256 or an *ecdsa.PublicKey on P-256, P-384 or P-521 for
+// ECDH-ES, the content is always encrypted with A256GCM. KeyID goes in the "kid" header.
+type Encrypter struct {
+	KeyID     string
+	Algorithm string
+	Key       interface{}
+}
+
+// Decrypter decrypts the tokens encrypted by its Encrypter. The key is an *rsa.PrivateKey
+// or an *ecdsa.PrivateKey.
+type Decrypter struct {
+	KeyID     string
+	Algorithm string
+	Key       interface{}
+}
+
+// NewEncrypter creates an Encrypter, the key management algorithm follows the key type.
+func NewEncrypter(key interface{}) (*Encrypter, error) {
+	alg, err := keyManagementAlg(key, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypter{Algorithm: alg, Key: key}, nil
+}
+
+// NewDecrypter creates a Decrypter, the key management algorithm follows the key type.
+func NewDecrypter(key interface{}) (*Decrypter, error) {
+	alg, err := keyManagementAlg(key, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Decrypter{Algorithm: alg, Key: key}, nil
+}
+
+// Encrypter returns the Encrypter producing tokens this Decrypter can decrypt.
+func (d *Decrypter) Encrypter() (*Encrypter, error) {
+	var public interface{}
+	switch key := d.Key.(type) {
+	case *rsa.PrivateKey:
+		public = &key.PublicKey
+	case *ecdsa.PrivateKey:
+		public = &key.PublicKey
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrKeyNotSuitable, d.Key)
+	}
+	encrypter, err := NewEncrypter(public)
+	if err != nil {
+		return nil, err
+	}
+	encrypter.KeyID = d.KeyID
+	return encrypter, nil
+}
+
+func keyManagementAlg(key interface{}, private bool) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !private {
+			return KeyAlgRSAOAEP256, nil
+		}
+	case *rsa.PrivateKey:
+		if private {
+			return KeyAlgRSAOAEP256, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, err := k.ECDH(); err == nil && !private {
+			return KeyAlgECDHES, nil
+		}
+	case *ecdsa.PrivateKey:
+		if _, err := k.ECDH(); err == nil && private {
+			return KeyAlgECDHES, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %T for token encryption", ErrKeyNotSuitable, key)
+}
+
+type jweHeader struct {
+	Alg  string   `json:"alg"`
+	Enc  string   `json:"enc"`
+	Cty  string   `json:"cty,omitempty"`
+	Kid  string   `json:"kid,omitempty"`
+	Epk  *JWK     `json:"epk,omitempty"`
+	Zip  string   `json:"zip,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// Encrypt encrypts the payload into a JWE compact serialization. The content type, such
+// as "JWT" for a nested token, goes in the "cty" header when not empty.
+func (e *Encrypter) Encrypt(payload []byte, contentType string) (string, error) {
+	header := &jweHeader{Alg: e.Algorithm, Enc: EncA256GCM, Cty: contentType, Kid: e.KeyID}
+	var cek, encryptedKey []byte
+	switch e.Algorithm {
+	case KeyAlgRSAOAEP256:
+		pub, ok := e.Key.(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("%w: %T for %s", ErrKeyNotSuitable, e.Key, e.Algorithm)
+		}
+		cek = make([]byte, 32)
+		if _, err := rand.Read(cek); err != nil {
+			return "", err
+		}
+		var err error
+		if encryptedKey, err = EncryptWithPublicKey(cek, pub); err != nil {
+			return "", err
+		}
+	case KeyAlgECDHES:
+		pub, ok := e.Key.(*ecdsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("%w: %T for %s", ErrKeyNotSuitable, e.Key, e.Algorithm)
+		}
+		recipient, err := pub.ECDH()
+		if err != nil {
+			return "", err
+		}
+		ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		if header.Epk, err = ecdhPublicKeyToJWK(pub, ephemeral.PublicKey()); err != nil {
+			return "", err
+		}
+		z, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return "", err
+		}
+		cek = concatKDF(z, EncA256GCM, 256)
+	default:
+		return "", fmt.Errorf("%w: alg %s", ErrUnsupportedEncryption, e.Algorithm)
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+	gcm, err := newA256GCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, payload, []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Decrypt decrypts a JWE compact serialization made by the Encrypter of this Decrypter.
+func (d *Decrypter) Decrypt(token string) ([]byte, error) {
+	payload, _, err := d.decrypt(token)
+	return payload, err
+}
+
+func (d *Decrypter) decrypt(token string) ([]byte, *jweHeader, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, nil, fmt.Errorf("%w: not a JWE compact serialization", ErrMalformed)
+	}
+	decoded := make([][]byte, 5)
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrMalformed, err.Error())
+		}
+	}
+	header := &jweHeader{}
+	if err := json.Unmarshal(decoded[0], header); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrMalformed, err.Error())
+	}
+	alg := d.Algorithm
+	if alg == "" {
+		var err error
+		if alg, err = keyManagementAlg(d.Key, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	if header.Alg != alg || header.Enc != EncA256GCM || header.Zip != "" || len(header.Crit) > 0 {
+		return nil, nil, fmt.Errorf("%w: alg %s enc %s", ErrUnsupportedEncryption, header.Alg, header.Enc)
+	}
+	if header.Kid != "" && d.KeyID != "" && header.Kid != d.KeyID {
+		return nil, nil, fmt.Errorf("%w: kid %s", ErrUnknownKey, header.Kid)
+	}
+
+	var cek []byte
+	switch header.Alg {
+	case KeyAlgRSAOAEP256:
+		priv, ok := d.Key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %T for %s", ErrKeyNotSuitable, d.Key, header.Alg)
+		}
+		var err error
+		if cek, err = DecryptWithPrivateKey(decoded[1], priv); err != nil {
+			return nil, nil, err
+		}
+	case KeyAlgECDHES:
+		priv, ok := d.Key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %T for %s", ErrKeyNotSuitable, d.Key, header.Alg)
+		}
+		if header.Epk == nil || len(decoded[1]) != 0 {
+			return nil, nil, fmt.Errorf("%w: invalid ECDH-ES key agreement", ErrMalformed)
+		}
+		epk, err := header.Epk.PublicKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		ephemeral, ok := epk.(*ecdsa.PublicKey)
+		if !ok || ephemeral.Curve != priv.Curve {
+			return nil, nil, fmt.Errorf("%w: epk is not on the key curve", ErrMalformed)
+		}
+		recipient, err := priv.ECDH()
+		if err != nil {
+			return nil, nil, err
+		}
+		ephemeralECDH, err := ephemeral.ECDH()
+		if err != nil {
+			return nil, nil, err
+		}
+		z, err := recipient.ECDH(ephemeralECDH)
+		if err != nil {
+			return nil, nil, err
+		}
+		cek = concatKDF(z, EncA256GCM, 256)
+	}
+
+	gcm, err := newA256GCM(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(decoded[2]) != gcm.NonceSize() || len(decoded[4]) != gcm.Overhead() {
+		return nil, nil, fmt.Errorf("%w: invalid iv or tag", ErrMalformed)
+	}
+	payload, err := gcm.Open(nil, decoded[2], append(decoded[3], decoded[4]...), []byte(parts[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	return payload, header, nil
+}
+
+func newA256GCM(cek []byte) (cipher.AEAD, error) {
+	if len(cek) != 32 {
+		return nil, fmt.Errorf("%w: content key of %d bytes for %s", ErrUnsupportedEncryption, len(cek), EncA256GCM)
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// concatKDF derives the content key from the ECDH-ES shared secret as specified by
+// RFC 7518 section 4.6.2, with empty "apu" and "apv". A single SHA-256 round is enough
+// for the 256 bits keys of A256GCM.
+func concatKDF(z []byte, algID string, keyBits int) []byte {
+	otherInfo := make([]byte, 0, 4+len(algID)+4+4+4)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(algID)))
+	otherInfo = append(otherInfo, algID...)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, 0)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, 0)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keyBits))
+
+	hash := sha256.New()
+	hash.Write([]byte{0, 0, 0, 1})
+	hash.Write(z)
+	hash.Write(otherInfo)
+	return hash.Sum(nil)[:keyBits/8]
+}
+
+// ecdhPublicKeyToJWK turns the ephemeral key into the "epk" header, on the recipient curve.
+func ecdhPublicKeyToJWK(recipient *ecdsa.PublicKey, ephemeral *ecdh.PublicKey) (*JWK, error) {
+	point := ephemeral.Bytes()
+	size := (len(point) - 1) / 2
+	jwk, err := PublicKeyToJWK(recipient)
+	if err != nil {
+		return nil, err
+	}
+	return &JWK{
+		Kty: jwk.Kty,
+		Crv: jwk.Crv,
+		X:   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+		Y:   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+	}, nil
+}
+
+// ToEncryptedToken signs the claim with the signer, then encrypts the signed token with
+// the encrypter, producing a nested JWT only the holder of the Decrypter can read.
+func (gc *GoClaim) ToEncryptedToken(signer *Signer, encrypter *Encrypter) (string, error) {
+	if encrypter == nil {
+		return "", fmt.Errorf("%w: no encrypter", ErrUnsupportedEncryption)
+	}
+	signed, err := gc.ToTokenWith(signer)
+	if err != nil {
+		return "", err
+	}
+	return encrypter.Encrypt([]byte(signed), "JWT")
+}
+
+// isEncryptedToken tells a JWE compact serialization, five parts, from a JWS, three parts.
+func isEncryptedToken(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// decryptNestedToken returns the signed token nested in the encrypted one.
+func decryptNestedToken(token string, decrypter *Decrypter) (string, error) {
+	if decrypter == nil {
+		return "", &TokenError{Err: ErrTokenUndecryptable, Cause: ErrNoDecrypter}
+	}
+	payload, header, err := decrypter.decrypt(token)
+	if err != nil {
+		return "", &TokenError{Err: ErrTokenUndecryptable, Cause: err}
+	}
+	if !strings.EqualFold(header.Cty, "JWT") {
+		return "", newTokenError(ErrTokenUndecryptable, "content type %q is not a nested JWT", header.Cty)
+	}
+	return string(payload), nil
+}
@@ -0,0 +1,103 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWE_RoundTrip(t *testing.T) {
+	rsaKey, _, err := GenerateKeyPair(2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+
+	for _, key := range []interface{}{rsaKey, ecKey} {
+		decrypter, err := NewDecrypter(key)
+		assert.NoError(t, err)
+		decrypter.KeyID = "enc-1"
+		encrypter, err := decrypter.Encrypter()
+		assert.NoError(t, err)
+		assert.Equal(t, "enc-1", encrypter.KeyID)
+
+		token, err := encrypter.Encrypt([]byte("confidential"), "")
+		assert.NoError(t, err)
+		assert.Len(t, strings.Split(token, "."), 5)
+		header, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+		assert.NoError(t, err)
+		values := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal(header, &values))
+		assert.Equal(t, decrypter.Algorithm, values["alg"])
+		assert.Equal(t, EncA256GCM, values["enc"])
+		assert.Equal(t, "enc-1", values["kid"])
+
+		payload, err := decrypter.Decrypt(token)
+		assert.NoError(t, err)
+		assert.Equal(t, "confidential", string(payload))
+
+		// any change of the protected header breaks the authentication tag
+		parts := strings.Split(token, ".")
+		values["cty"] = "JWT"
+		tampered, _ := json.Marshal(values)
+		parts[0] = base64.RawURLEncoding.EncodeToString(tampered)
+		_, err = decrypter.Decrypt(strings.Join(parts, "."))
+		assert.Error(t, err)
+	}
+
+	_, err = NewEncrypter(rsaKey)
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+	_, err = NewDecrypter([]byte("secret"))
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+}
+
+func TestJWE_AlgorithmMismatch(t *testing.T) {
+	rsaKey, _, err := GenerateKeyPair(2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	rsaDecrypter, _ := NewDecrypter(rsaKey)
+	ecEncrypter, _ := NewEncrypter(&ecKey.PublicKey)
+
+	token, err := ecEncrypter.Encrypt([]byte("confidential"), "")
+	assert.NoError(t, err)
+	_, err = rsaDecrypter.Decrypt(token)
+	assert.ErrorIs(t, err, ErrUnsupportedEncryption)
+}
+
+func TestTokenParser_EncryptedToken(t *testing.T) {
+	signer := newTestECSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	decrypter, err := NewDecrypter(ecKey)
+	assert.NoError(t, err)
+	encrypter, err := decrypter.Encrypter()
+	assert.NoError(t, err)
+
+	claim := &GoClaim{Subscriber: "subs@Issuer.com", Audience: []string{"tenant@role"}, ExpireAt: time.Now().Add(time.Hour)}
+	token, err := claim.ToEncryptedToken(signer, encrypter)
+	assert.NoError(t, err)
+	assert.NotContains(t, token, base64.RawURLEncoding.EncodeToString([]byte(`"sub"`)))
+
+	gc, err := (&TokenParser{Resolver: verifier, Decrypter: decrypter}).Parse(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "subs@Issuer.com", gc.Subscriber)
+	assert.Equal(t, []string{"tenant@role"}, gc.Audience)
+
+	_, err = (&TokenParser{Resolver: verifier}).Parse(token)
+	assert.ErrorIs(t, err, ErrTokenUndecryptable)
+	assert.ErrorIs(t, err, ErrNoDecrypter)
+
+	// an encrypted token must nest a signed one
+	unsigned, err := encrypter.Encrypt([]byte(`{"sub":"subs@Issuer.com"}`), "")
+	assert.NoError(t, err)
+	_, err = (&TokenParser{Resolver: verifier, Decrypter: decrypter}).Parse(unsigned)
+	assert.ErrorIs(t, err, ErrTokenUndecryptable)
+}
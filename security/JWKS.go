@@ -0,0 +1,253 @@
+package security
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// JWKSPath is the well known location a service publishes its JWKS document at.
+// Peer apps on the same Dokku host can discover the signing keys of a service at
+// http://<app>.web:<port>/.well-known/jwks.json
+const JWKSPath = "/.well-known/jwks.json"
+
+var (
+	ErrKeyNotPublishable = fmt.Errorf("key can not be published in a JWKS")
+	ErrInvalidJWK        = fmt.Errorf("invalid JWK")
+)
+
+// JWK is a public key as described by RFC 7517. Only the members needed for
+// RSA, EC and OKP (Ed25519) signature keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JWK Set document.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// PublicKeyToJWK public key to JWK, key must be an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func PublicKeyToJWK(key interface{}) (*JWK, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		x := make([]byte, size)
+		y := make([]byte, size)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return &JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(x),
+			Y:   base64.RawURLEncoding.EncodeToString(y),
+		}, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrKeyNotPublishable, key)
+}
+
+// PublicKey JWK to public key, returns an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (jwk *JWK) PublicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("%w: bad RSA modulus", ErrInvalidJWK)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: bad RSA exponent", ErrInvalidJWK)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("%w: unsupported curve %s", ErrInvalidJWK, jwk.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("%w: bad EC coordinates", ErrInvalidJWK)
+		}
+		// crypto/ecdh rejects points that are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidJWK, err.Error())
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: bad OKP key", ErrInvalidJWK)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported key type %s", ErrInvalidJWK, jwk.Kty)
+}
+
+// Verifier returns the verifier checking tokens signed with alg by this key.
+// It fails if the key is not a signature key, is bound to another algorithm or
+// does not suit alg.
+func (jwk *JWK) Verifier(alg string) (*Verifier, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, fmt.Errorf("%w: key %s is for \"%s\"", ErrUnknownKey, jwk.Kid, jwk.Use)
+	}
+	if jwk.Alg != "" && jwk.Alg != alg {
+		return nil, fmt.Errorf("%w: key %s is for %s, not %s", ErrUnknownKey, jwk.Kid, jwk.Alg, alg)
+	}
+	method := SigningMethodByAlg(alg)
+	if method == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningMethod, alg)
+	}
+	key, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := NewVerifier(method, key)
+	if err != nil {
+		return nil, err
+	}
+	verifier.KeyID = jwk.Kid
+	return verifier, nil
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key, base64url encoded.
+func (jwk *JWK) Thumbprint() string {
+	// the required members, in lexicographic order, without white space
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%s,"kty":%s,"n":%s}`, strconv.Quote(jwk.E), strconv.Quote(jwk.Kty), strconv.Quote(jwk.N))
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%s,"kty":%s,"x":%s,"y":%s}`, strconv.Quote(jwk.Crv), strconv.Quote(jwk.Kty), strconv.Quote(jwk.X), strconv.Quote(jwk.Y))
+	default:
+		members = fmt.Sprintf(`{"crv":%s,"kty":%s,"x":%s}`, strconv.Quote(jwk.Crv), strconv.Quote(jwk.Kty), strconv.Quote(jwk.X))
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWK returns the public JWK of the verifier with its "alg" and "use" set.
+// The RFC 7638 thumbprint is used as "kid" when the verifier has no KeyID.
+// HMAC verifiers hold a shared secret and can not be published.
+func (v *Verifier) JWK() (*JWK, error) {
+	jwk, err := PublicKeyToJWK(v.Key)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Alg = v.Method.Alg()
+	jwk.Use = "sig"
+	jwk.Kid = v.KeyID
+	if jwk.Kid == "" {
+		jwk.Kid = jwk.Thumbprint()
+	}
+	return jwk, nil
+}
+
+// VerificationKeySource supplies the keys a JWKSHandler publishes.
+type VerificationKeySource interface {
+	VerificationKeys() []*Verifier
+}
+
+// StaticKeys is a VerificationKeySource over a fixed list of verifiers.
+type StaticKeys []*Verifier
+
+// VerificationKeys returns the verifiers of the list.
+func (sk StaticKeys) VerificationKeys() []*Verifier {
+	return sk
+}
+
+// JWKSHandler serves the public keys of its Source as a JWKS document.
+// Mount it at JWKSPath.
+type JWKSHandler struct {
+	Source VerificationKeySource
+	// MaxAge is sent in the Cache-Control header so consumers know how long they may cache the keys.
+	MaxAge time.Duration
+}
+
+// NewJWKSHandler creates a JWKSHandler publishing the keys of source, cacheable for 5 minutes.
+func NewJWKSHandler(source VerificationKeySource) *JWKSHandler {
+	return &JWKSHandler{
+		Source: source,
+		MaxAge: 5 * time.Minute,
+	}
+}
+
+// JWKS builds the document from the current keys of the source, leaving out the
+// keys that can not be published, such as HMAC secrets.
+func (h *JWKSHandler) JWKS() *JWKS {
+	jwks := &JWKS{Keys: make([]*JWK, 0)}
+	for _, verifier := range h.Source.VerificationKeys() {
+		jwk, err := verifier.JWK()
+		if err != nil {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func (h *JWKSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := json.Marshal(h.JWKS())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.MaxAge.Seconds())))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
@@ -0,0 +1,87 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	publicBytes, err := keyFs.ReadFile("testkey/public.pem")
+	assert.NoError(t, err)
+	pubk, err := BytesToPublicKey(publicBytes)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	rsaVerifier, err := NewVerifier(crypto.SigningMethodRS512, pubk)
+	assert.NoError(t, err)
+	rsaVerifier.KeyID = "rsa-1"
+	ecVerifier, err := NewVerifier(SigningMethodES256, &ecKey.PublicKey)
+	assert.NoError(t, err)
+	edVerifier, err := NewVerifier(SigningMethodEdDSA, edPub)
+	assert.NoError(t, err)
+	hmacVerifier, err := NewVerifier(crypto.SigningMethodHS256, []byte(MakeRandomText(32)))
+	assert.NoError(t, err)
+
+	handler := NewJWKSHandler(StaticKeys{rsaVerifier, ecVerifier, edVerifier, hmacVerifier})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/jwk-set+json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+
+	jwks := &JWKS{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), jwks))
+	assert.Len(t, jwks.Keys, 3)
+
+	assert.Equal(t, "RSA", jwks.Keys[0].Kty)
+	assert.Equal(t, "rsa-1", jwks.Keys[0].Kid)
+	assert.Equal(t, "RS512", jwks.Keys[0].Alg)
+	assert.Equal(t, "sig", jwks.Keys[0].Use)
+	assert.Equal(t, "AQAB", jwks.Keys[0].E)
+
+	assert.Equal(t, "EC", jwks.Keys[1].Kty)
+	assert.Equal(t, "P-256", jwks.Keys[1].Crv)
+	assert.Equal(t, "ES256", jwks.Keys[1].Alg)
+	assert.Len(t, jwks.Keys[1].X, 43)
+	assert.Len(t, jwks.Keys[1].Y, 43)
+	assert.Equal(t, jwks.Keys[1].Thumbprint(), jwks.Keys[1].Kid)
+
+	assert.Equal(t, "OKP", jwks.Keys[2].Kty)
+	assert.Equal(t, "Ed25519", jwks.Keys[2].Crv)
+	assert.Equal(t, "EdDSA", jwks.Keys[2].Alg)
+	assert.Len(t, jwks.Keys[2].Kid, 43)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, JWKSPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// example of RFC 7638 section 3.1
+	rfc := &JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", rfc.Thumbprint())
+
+	// only the required members are part of the thumbprint
+	jwk := &JWK{Kty: "EC", Crv: "P-256", X: "abc", Y: "def"}
+	withExtra := &JWK{Kty: "EC", Crv: "P-256", X: "abc", Y: "def", Kid: "k", Alg: "ES256", Use: "sig"}
+	assert.Equal(t, jwk.Thumbprint(), withExtra.Thumbprint())
+	assert.NotEqual(t, jwk.Thumbprint(), (&JWK{Kty: "EC", Crv: "P-256", X: "abc", Y: "deg"}).Thumbprint())
+}
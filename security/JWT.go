@@ -3,6 +3,8 @@ package security
 import (
 	"bytes"
 	"crypto/rsa"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/SermoDigital/jose/crypto"
 	"github.com/SermoDigital/jose/jws"
@@ -21,18 +23,64 @@ const (
 
 var (
 	ErrKeyPEMNotFound = fmt.Errorf("Key PEM file not found")
+	ErrNoVerifier     = fmt.Errorf("no verifier to check the token signature")
+	ErrNoSigner       = fmt.Errorf("no signer to sign the token")
+
+	// registeredClaims are the claim names mapped onto GoClaim fields. Every other
+	// claim in a token travels in GoClaim.Custom.
+	registeredClaims = map[string]bool{
+		"iss": true,
+		"sub": true,
+		"aud": true,
+		"nbf": true,
+		"iat": true,
+		"exp": true,
+		"jti": true,
+		"typ": true,
+	}
 )
 
+// NewGoClaimFromToken parses the token and verifies its signature with the RSA key, or with
+// the DefaultKeyResolver when the key is nil. Without either, the token is not verified.
 func NewGoClaimFromToken(tokenString string, verifyKey *rsa.PublicKey, signM *crypto.SigningMethodRSA) (*GoClaim, error) {
+	if verifyKey == nil {
+		if DefaultKeyResolver != nil {
+			return NewGoClaimFromTokenWith(tokenString, DefaultKeyResolver)
+		}
+		return parseGoClaim(tokenString, nil, nil)
+	}
+	return NewGoClaimFromTokenWith(tokenString, &Verifier{Method: signM, Key: verifyKey})
+}
+
+// NewGoClaimFromTokenWith parses the token and verifies its signature with the key the
+// resolver finds for the token "kid" and "alg" header. A *Verifier is a resolver for itself.
+// The claims are checked against the DefaultValidationPolicy, use a TokenParser for another one.
+func NewGoClaimFromTokenWith(tokenString string, resolver KeyResolver) (*GoClaim, error) {
+	parser := &TokenParser{Resolver: resolver, Policy: DefaultValidationPolicy, Revocations: DefaultRevocationStore, Decrypter: DefaultDecrypter}
+	return parser.Parse(tokenString)
+}
+
+// parseGoClaim parses the token, without verifying it at all when the resolver is nil.
+func parseGoClaim(tokenString string, resolver KeyResolver, policy *ValidationPolicy) (*GoClaim, error) {
 	jwt, err := jws.ParseJWT([]byte(tokenString))
 	if err != nil {
-		return nil, fmt.Errorf("malformed jwt token")
+		return nil, &TokenError{Err: ErrMalformed, Cause: err}
 	}
 
-	if verifyKey != nil {
-		if err := jwt.Validate(verifyKey, signM); err != nil {
+	if resolver != nil {
+		token := jwt.(jws.JWS)
+		kid, _ := token.Protected().Get("kid").(string)
+		alg, _ := token.Protected().Get("alg").(string)
+		verifier, err := resolver.ResolveKey(kid, alg)
+		if errors.Is(err, ErrUnknownKey) {
+			return nil, newTokenError(ErrUnknownKey, "kid %q alg %q", kid, alg)
+		}
+		if err != nil {
 			return nil, err
 		}
+		if err := token.Verify(verifier.Key, verifier.Method); err != nil {
+			return nil, &TokenError{Err: ErrSignatureInvalid, Detail: fmt.Sprintf("alg %q", alg), Cause: err}
+		}
 	}
 	claims := jwt.Claims()
 	gc := &GoClaim{}
@@ -65,6 +113,16 @@ func NewGoClaimFromToken(tokenString string, verifyKey *rsa.PublicKey, signM *cr
 			gc.TokenType = AccessToken
 		}
 	}
+	for name, value := range claims {
+		if !registeredClaims[name] {
+			gc.SetClaim(name, value)
+		}
+	}
+	if resolver != nil {
+		if err := policy.Validate(gc); err != nil {
+			return nil, err
+		}
+	}
 	return gc, nil
 }
 
@@ -77,6 +135,78 @@ type GoClaim struct {
 	IssuedAt   time.Time
 	ExpireAt   time.Time
 	Tokenid    string
+
+	// Custom holds every non registered claim, such as "email" or "client_id".
+	// Entries named after a registered claim are ignored by ToToken.
+	Custom map[string]any
+}
+
+// HasClaim tells whether the claim called name, registered or custom, is set.
+func (gc *GoClaim) HasClaim(name string) bool {
+	switch name {
+	case "iss":
+		return gc.Issuer != ""
+	case "sub":
+		return gc.Subscriber != ""
+	case "aud":
+		return len(gc.Audience) > 0
+	case "nbf":
+		return !gc.NotBefore.IsZero()
+	case "iat":
+		return !gc.IssuedAt.IsZero()
+	case "exp":
+		return !gc.ExpireAt.IsZero()
+	case "jti":
+		return gc.Tokenid != ""
+	case "typ":
+		return gc.TokenType != ""
+	}
+	_, ok := gc.Custom[name]
+	return ok
+}
+
+// SetClaim sets a custom claim, overwriting any previous value of the same name.
+func (gc *GoClaim) SetClaim(name string, value any) {
+	if gc.Custom == nil {
+		gc.Custom = make(map[string]any)
+	}
+	gc.Custom[name] = value
+}
+
+// DecodeCustom decodes the custom claims into v, which is typically a pointer to
+// a struct with json tags describing the application specific claims.
+func (gc *GoClaim) DecodeCustom(v any) error {
+	data, err := json.Marshal(gc.Custom)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ClaimAs returns the custom claim called name converted to T. Claims parsed from
+// a token carry their JSON representation (numbers are float64, objects are maps),
+// so values that are not already a T are converted through encoding/json.
+// The boolean result is false if the claim is absent or can not be converted.
+func ClaimAs[T any](gc *GoClaim, name string) (T, bool) {
+	var ret T
+	if gc == nil || gc.Custom == nil {
+		return ret, false
+	}
+	value, ok := gc.Custom[name]
+	if !ok {
+		return ret, false
+	}
+	if typed, ok := value.(T); ok {
+		return typed, true
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ret, false
+	}
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return ret, false
+	}
+	return ret, true
 }
 
 func (gc *GoClaim) String() string {
@@ -148,7 +278,16 @@ func (gc *GoClaim) String() string {
 }
 
 func (gc *GoClaim) ToToken(signing *rsa.PrivateKey, signM *crypto.SigningMethodRSA) (string, error) {
+	return gc.ToTokenWith(&Signer{Method: signM, Key: signing})
+}
 
+// ToTokenWith signs the claim with the signer, which may use any of the algorithms
+// supported by NewSigner. The signer KeyID, such as the one of a KeyRing signer, goes
+// in the "kid" header.
+func (gc *GoClaim) ToTokenWith(signer *Signer) (string, error) {
+	if signer == nil {
+		return "", ErrNoSigner
+	}
 	claims := jws.Claims{}
 
 	if len(gc.Issuer) > 0 {
@@ -173,9 +312,20 @@ func (gc *GoClaim) ToToken(signing *rsa.PrivateKey, signM *crypto.SigningMethodR
 	if gc.TokenType != "" && len(gc.TokenType) > 0 {
 		claims.Set("typ", gc.TokenType)
 	}
+	if len(gc.Tokenid) > 0 {
+		claims.SetJWTID(gc.Tokenid)
+	}
+	for name, value := range gc.Custom {
+		if !registeredClaims[name] {
+			claims.Set(name, value)
+		}
+	}
 
-	jwtBytes := jws.NewJWT(claims, signM)
-	tokenByte, err := jwtBytes.Serialize(signing)
+	jwtBytes := jws.NewJWT(claims, signer.Method)
+	if signer.KeyID != "" {
+		jwtBytes.(jws.JWS).Protected().Set("kid", signer.KeyID)
+	}
+	tokenByte, err := jwtBytes.Serialize(signer.Key)
 	if err != nil {
 		return "", err
 	}
@@ -86,3 +86,57 @@ Gr1F29l4A1wGjQt0oA3eTATFng+jD0MLmR3lP7elATNTmHawpBH6IqWX9eKVSJ8M
 	assert.NotNil(t, claim)
 
 }
+
+func TestGoClaim_CustomClaims(t *testing.T) {
+	type Profile struct {
+		Email    string `json:"email"`
+		Name     string `json:"name"`
+		ClientID string `json:"client_id"`
+		Level    int    `json:"level"`
+	}
+
+	claim := &GoClaim{
+		Issuer:     "Issuer.com",
+		Subscriber: "subs@Issuer.com",
+		TokenType:  AccessToken,
+	}
+	claim.SetClaim("email", "subs@Issuer.com")
+	claim.SetClaim("name", "Subscriber")
+	claim.SetClaim("client_id", "dokku-app")
+	claim.SetClaim("level", 3)
+	claim.SetClaim("sub", "ignored")
+
+	privateBytes, err := keyFs.ReadFile("testkey/private.pem")
+	assert.NoError(t, err)
+	privk, err := BytesToPrivateKey(privateBytes)
+	assert.NoError(t, err)
+	publicBytes, err := keyFs.ReadFile("testkey/public.pem")
+	assert.NoError(t, err)
+	pubk, err := BytesToPublicKey(publicBytes)
+	assert.NoError(t, err)
+
+	token, err := claim.ToToken(privk, crypto.SigningMethodRS512)
+	assert.NoError(t, err)
+
+	klaim, err := NewGoClaimFromToken(token, pubk, crypto.SigningMethodRS512)
+	assert.NoError(t, err)
+	assert.Equal(t, "subs@Issuer.com", klaim.Subscriber)
+	assert.Len(t, klaim.Custom, 4)
+
+	email, ok := ClaimAs[string](klaim, "email")
+	assert.True(t, ok)
+	assert.Equal(t, "subs@Issuer.com", email)
+
+	level, ok := ClaimAs[int](klaim, "level")
+	assert.True(t, ok)
+	assert.Equal(t, 3, level)
+
+	_, ok = ClaimAs[int](klaim, "email")
+	assert.False(t, ok)
+	_, ok = ClaimAs[string](klaim, "missing")
+	assert.False(t, ok)
+
+	profile := &Profile{}
+	assert.NoError(t, klaim.DecodeCustom(profile))
+	assert.Equal(t, Profile{Email: "subs@Issuer.com", Name: "Subscriber", ClientID: "dokku-app", Level: 3}, *profile)
+}
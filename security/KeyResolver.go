@@ -0,0 +1,255 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeyResolver finds the verifier of a token from the "kid" and "alg" of its header.
+type KeyResolver interface {
+	ResolveKey(kid, alg string) (*Verifier, error)
+}
+
+// DefaultKeyResolver, when set, verifies the tokens NewGoClaimFromToken is given no key for,
+// and so the tokens of the UserTokenContextMiddleware, for example with a JWKSResolver
+// following the keys published by the token issuer.
+var DefaultKeyResolver KeyResolver
+
+// ResolveKey returns the verifier itself, so a single Verifier can be used as a KeyResolver.
+// A token naming another key ID is refused, the algorithm is checked during verification.
+func (v *Verifier) ResolveKey(kid, alg string) (*Verifier, error) {
+	if v == nil {
+		return nil, ErrNoVerifier
+	}
+	if kid != "" && v.KeyID != "" && kid != v.KeyID {
+		return nil, fmt.Errorf("%w: kid %s", ErrUnknownKey, kid)
+	}
+	return v, nil
+}
+
+// ResolveKey returns the first verifier of the list with the token algorithm and,
+// when both the token and the verifier have one, the token key ID.
+func (sk StaticKeys) ResolveKey(kid, alg string) (*Verifier, error) {
+	for _, v := range sk {
+		if v.Method.Alg() != alg {
+			continue
+		}
+		if kid != "" && v.KeyID != "" && kid != v.KeyID {
+			continue
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: kid %s alg %s", ErrUnknownKey, kid, alg)
+}
+
+// JWKSResolver is a KeyResolver over the JWKS document published by a token issuer,
+// typically at its JWKSPath. Keys are cached by "kid". The document is fetched again
+// when it is older than RefreshInterval, one hour when zero, or when a token names an
+// unknown key, but never more often than MinRefreshInterval, one minute when zero.
+type JWKSResolver struct {
+	URL                string
+	Client             *http.Client
+	RefreshInterval    time.Duration
+	MinRefreshInterval time.Duration
+
+	mutex     sync.RWMutex
+	keys      map[string]*JWK
+	kids      []string
+	verifiers map[string]*Verifier
+	fetchedAt time.Time
+
+	fetchMutex sync.Mutex
+	attemptAt  time.Time
+
+	stop chan struct{}
+}
+
+// NewJWKSResolver creates a resolver for the JWKS document at url, refreshed every hour
+// and at most once a minute on unknown keys.
+func NewJWKSResolver(url string) *JWKSResolver {
+	return &JWKSResolver{
+		URL:                url,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+		RefreshInterval:    time.Hour,
+		MinRefreshInterval: time.Minute,
+	}
+}
+
+func (r *JWKSResolver) refreshInterval() time.Duration {
+	if r.RefreshInterval > 0 {
+		return r.RefreshInterval
+	}
+	return time.Hour
+}
+
+func (r *JWKSResolver) minRefreshInterval() time.Duration {
+	if r.MinRefreshInterval > 0 {
+		return r.MinRefreshInterval
+	}
+	return time.Minute
+}
+
+// Start fetches the document and keeps refreshing it every RefreshInterval in the
+// background until Stop is called. The error of the initial fetch is returned, but
+// the background refresh keeps running regardless.
+func (r *JWKSResolver) Start() error {
+	r.mutex.Lock()
+	if r.stop != nil {
+		r.mutex.Unlock()
+		return nil
+	}
+	stop := make(chan struct{})
+	r.stop = stop
+	r.mutex.Unlock()
+
+	err := r.Refresh(context.Background())
+	go func() {
+		ticker := time.NewTicker(r.refreshInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.Refresh(context.Background()); err != nil {
+					logrus.Warnf("JWKS refresh from %s failed: %s", r.URL, err.Error())
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return err
+}
+
+// Stop ends the background refresh started by Start.
+func (r *JWKSResolver) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// Refresh fetches the JWKS document and replaces the cached keys.
+func (r *JWKSResolver) Refresh(ctx context.Context) error {
+	r.fetchMutex.Lock()
+	defer r.fetchMutex.Unlock()
+	return r.fetch(ctx)
+}
+
+func (r *JWKSResolver) fetch(ctx context.Context) error {
+	r.attemptAt = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS %s responded with status %d", r.URL, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	jwks := &JWKS{}
+	if err := json.Unmarshal(body, jwks); err != nil {
+		return fmt.Errorf("malformed JWKS from %s: %w", r.URL, err)
+	}
+
+	keys := make(map[string]*JWK, len(jwks.Keys))
+	kids := make([]string, 0, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk == nil {
+			continue
+		}
+		kid := jwk.Kid
+		if kid == "" {
+			kid = jwk.Thumbprint()
+		}
+		if _, exist := keys[kid]; !exist {
+			kids = append(kids, kid)
+		}
+		keys[kid] = jwk
+	}
+
+	r.mutex.Lock()
+	r.keys = keys
+	r.kids = kids
+	r.verifiers = make(map[string]*Verifier)
+	r.fetchedAt = time.Now()
+	r.mutex.Unlock()
+	return nil
+}
+
+// refreshIfAllowed fetches the document again unless it was attempted within the last
+// MinRefreshInterval, so tokens with made up key IDs can not flood the issuer.
+func (r *JWKSResolver) refreshIfAllowed() {
+	r.fetchMutex.Lock()
+	defer r.fetchMutex.Unlock()
+	if !r.attemptAt.IsZero() && time.Since(r.attemptAt) < r.minRefreshInterval() {
+		return
+	}
+	if err := r.fetch(context.Background()); err != nil {
+		logrus.Warnf("JWKS refresh from %s failed: %s", r.URL, err.Error())
+	}
+}
+
+func (r *JWKSResolver) lookup(kid, alg string) (*Verifier, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	kids := r.kids
+	if kid != "" {
+		kids = []string{kid}
+	}
+	for _, k := range kids {
+		if verifier, ok := r.verifiers[k+" "+alg]; ok {
+			return verifier, true
+		}
+		jwk, ok := r.keys[k]
+		if !ok {
+			continue
+		}
+		if verifier, err := jwk.Verifier(alg); err == nil {
+			r.verifiers[k+" "+alg] = verifier
+			return verifier, true
+		}
+	}
+	return nil, false
+}
+
+func (r *JWKSResolver) stale() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.fetchedAt.IsZero() || time.Since(r.fetchedAt) > r.refreshInterval()
+}
+
+// ResolveKey returns the verifier of the key named kid that suits alg. Without kid,
+// the first key suiting alg is used.
+func (r *JWKSResolver) ResolveKey(kid, alg string) (*Verifier, error) {
+	if r.stale() {
+		r.refreshIfAllowed()
+	}
+	if verifier, ok := r.lookup(kid, alg); ok {
+		return verifier, nil
+	}
+	r.refreshIfAllowed()
+	if verifier, ok := r.lookup(kid, alg); ok {
+		return verifier, nil
+	}
+	return nil, fmt.Errorf("%w: kid %s alg %s", ErrUnknownKey, kid, alg)
+}
@@ -0,0 +1,170 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testIssuer struct {
+	mutex   sync.Mutex
+	signers map[string]*Signer
+	keys    StaticKeys
+	hits    int32
+	server  *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{signers: make(map[string]*Signer)}
+	handler := NewJWKSHandler(issuer)
+	issuer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&issuer.hits, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (ti *testIssuer) VerificationKeys() []*Verifier {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	return ti.keys
+}
+
+func (ti *testIssuer) addKey(t *testing.T, kid string) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := NewSigner(SigningMethodES256, ecKey)
+	assert.NoError(t, err)
+	signer.KeyID = kid
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	ti.signers[kid] = signer
+	ti.keys = append(ti.keys, verifier)
+}
+
+func (ti *testIssuer) token(t *testing.T, kid string) string {
+	signer := ti.signers[kid]
+	token, err := (&GoClaim{Subscriber: "subs@Issuer.com", ExpireAt: time.Now().Add(time.Hour)}).ToTokenWith(signer)
+	assert.NoError(t, err)
+	return token
+}
+
+func (ti *testIssuer) hitCount() int {
+	return int(atomic.LoadInt32(&ti.hits))
+}
+
+func TestJWKSResolver_CachesAndRefreshesOnUnknownKid(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.addKey(t, "key-1")
+
+	resolver := NewJWKSResolver(issuer.server.URL + JWKSPath)
+	resolver.MinRefreshInterval = time.Nanosecond
+
+	claim, err := NewGoClaimFromTokenWith(issuer.token(t, "key-1"), resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, "subs@Issuer.com", claim.Subscriber)
+	assert.Equal(t, 1, issuer.hitCount())
+
+	_, err = NewGoClaimFromTokenWith(issuer.token(t, "key-1"), resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, issuer.hitCount())
+
+	issuer.addKey(t, "key-2")
+	_, err = NewGoClaimFromTokenWith(issuer.token(t, "key-2"), resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, issuer.hitCount())
+
+	_, err = resolver.ResolveKey("key-2", "RS256")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestJWKSResolver_RateLimitsUnknownKid(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.addKey(t, "key-1")
+
+	resolver := NewJWKSResolver(issuer.server.URL + JWKSPath)
+	resolver.MinRefreshInterval = time.Hour
+
+	_, err := resolver.ResolveKey("key-1", "ES256")
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = resolver.ResolveKey("made-up", "ES256")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	}
+	assert.Equal(t, 1, issuer.hitCount())
+}
+
+func TestJWKSResolver_ZeroValueDefaults(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.addKey(t, "key-1")
+
+	resolver := &JWKSResolver{URL: issuer.server.URL + JWKSPath}
+	assert.NoError(t, resolver.Start())
+	defer resolver.Stop()
+	for i := 0; i < 10; i++ {
+		_, err := resolver.ResolveKey("made-up", "ES256")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	}
+	assert.Equal(t, 1, issuer.hitCount())
+}
+
+func TestJWKSResolver_BackgroundRefresh(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.addKey(t, "key-1")
+
+	resolver := NewJWKSResolver(issuer.server.URL + JWKSPath)
+	resolver.RefreshInterval = 20 * time.Millisecond
+	resolver.MinRefreshInterval = time.Hour
+	assert.NoError(t, resolver.Start())
+	defer resolver.Stop()
+
+	issuer.addKey(t, "key-2")
+	assert.Eventually(t, func() bool {
+		_, err := resolver.ResolveKey("key-2", "ES256")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewGoClaimFromToken_DefaultKeyResolver(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.addKey(t, "key-1")
+	DefaultKeyResolver = NewJWKSResolver(issuer.server.URL + JWKSPath)
+	defer func() { DefaultKeyResolver = nil }()
+
+	claim, err := NewGoClaimFromToken(issuer.token(t, "key-1"), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "subs@Issuer.com", claim.Subscriber)
+	_, err = NewGoClaimFromToken(issuer.token(t, "key-1")+"x", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestStaticKeys_ResolveKey(t *testing.T) {
+	secret := []byte(MakeRandomText(32))
+	hs, err := NewVerifier(crypto.SigningMethodHS256, secret)
+	assert.NoError(t, err)
+	hs.KeyID = "hs"
+	keys := StaticKeys{hs}
+
+	v, err := keys.ResolveKey("hs", "HS256")
+	assert.NoError(t, err)
+	assert.Equal(t, hs, v)
+	v, err = keys.ResolveKey("", "HS256")
+	assert.NoError(t, err)
+	assert.Equal(t, hs, v)
+	_, err = keys.ResolveKey("other", "HS256")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = keys.ResolveKey("hs", "HS512")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
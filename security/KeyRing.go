@@ -0,0 +1,202 @@
+package security
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoActiveKey    = fmt.Errorf("key ring has no active signing key")
+	ErrDuplicateKeyID = fmt.Errorf("key ID already in the key ring")
+	ErrUnknownKeyID   = fmt.Errorf("key ID not in the key ring")
+)
+
+// RingKey is a key of a KeyRing. Signer is nil for keys that only verify tokens, such as
+// keys whose private part was destroyed after rotation. A zero NotBefore or NotAfter
+// leaves that side of the validity window open.
+type RingKey struct {
+	ID        string
+	Signer    *Signer
+	Verifier  *Verifier
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func (rk *RingKey) validAt(now time.Time) bool {
+	if !rk.NotBefore.IsZero() && now.Before(rk.NotBefore) {
+		return false
+	}
+	if !rk.NotAfter.IsZero() && !now.Before(rk.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// KeyRing holds one active signing key and any number of keys that still verify tokens,
+// each with an ID used as the token "kid" header. Tokens signed with the key ring Signer
+// carry the active key ID, and the key ring resolves the verification key by that ID, so
+// signing keys can be rotated while tokens signed by the previous key stay valid until
+// the key retires.
+// A KeyRing is a SignerSource, a KeyResolver and a VerificationKeySource for the JWKSHandler.
+type KeyRing struct {
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	mutex  sync.RWMutex
+	keys   []*RingKey
+	active string
+}
+
+// NewKeyRing creates an empty KeyRing.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{}
+}
+
+func (kr *KeyRing) now() time.Time {
+	if kr.Now != nil {
+		return kr.Now()
+	}
+	return time.Now()
+}
+
+func (kr *KeyRing) find(id string) *RingKey {
+	for _, key := range kr.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Add adds a key able to sign and verify tokens within the validity window. It does not
+// become the active signing key until Activate is called.
+func (kr *KeyRing) Add(id string, signer *Signer, notBefore, notAfter time.Time) error {
+	if signer == nil {
+		return ErrNoSigner
+	}
+	verifier, err := signer.Verifier()
+	if err != nil {
+		return err
+	}
+	return kr.add(&RingKey{ID: id, Signer: signer, Verifier: verifier, NotBefore: notBefore, NotAfter: notAfter})
+}
+
+// AddVerifier adds a key that only verifies tokens within the validity window.
+func (kr *KeyRing) AddVerifier(id string, verifier *Verifier, notBefore, notAfter time.Time) error {
+	if verifier == nil {
+		return ErrNoVerifier
+	}
+	return kr.add(&RingKey{ID: id, Verifier: verifier, NotBefore: notBefore, NotAfter: notAfter})
+}
+
+func (kr *KeyRing) add(key *RingKey) error {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	if kr.find(key.ID) != nil {
+		return fmt.Errorf("%w: %s", ErrDuplicateKeyID, key.ID)
+	}
+	if key.Signer != nil {
+		signer := *key.Signer
+		signer.KeyID = key.ID
+		key.Signer = &signer
+	}
+	verifier := *key.Verifier
+	verifier.KeyID = key.ID
+	key.Verifier = &verifier
+	kr.keys = append(kr.keys, key)
+	return nil
+}
+
+// Activate makes the key with the ID the signing key. The key must hold a signer.
+func (kr *KeyRing) Activate(id string) error {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	key := kr.find(id)
+	if key == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	if key.Signer == nil {
+		return fmt.Errorf("%w: key %s can only verify", ErrNoSigner, id)
+	}
+	kr.active = id
+	return nil
+}
+
+// Rotate adds the signer under the ID and makes it the active signing key. The previously
+// active key keeps verifying tokens for retireAfter, which should be at least the lifetime
+// of the tokens it signed, and is then retired.
+func (kr *KeyRing) Rotate(id string, signer *Signer, retireAfter time.Duration) error {
+	now := kr.now()
+	if err := kr.Add(id, signer, now, time.Time{}); err != nil {
+		return err
+	}
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	if previous := kr.find(kr.active); previous != nil {
+		retireAt := now.Add(retireAfter)
+		if previous.NotAfter.IsZero() || previous.NotAfter.After(retireAt) {
+			previous.NotAfter = retireAt
+		}
+	}
+	kr.active = id
+	return nil
+}
+
+// Remove drops the key with the ID. Tokens carrying its ID are refused from then on.
+func (kr *KeyRing) Remove(id string) {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	for i, key := range kr.keys {
+		if key.ID == id {
+			kr.keys = append(kr.keys[:i], kr.keys[i+1:]...)
+			break
+		}
+	}
+	if kr.active == id {
+		kr.active = ""
+	}
+}
+
+// Signer returns the active signing key, with its KeyID set so GoClaim.ToTokenWith
+// stamps it in the token "kid" header.
+func (kr *KeyRing) Signer() (*Signer, error) {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	key := kr.find(kr.active)
+	if key == nil || !key.validAt(kr.now()) {
+		return nil, ErrNoActiveKey
+	}
+	return key.Signer, nil
+}
+
+// ResolveKey returns the verifier of the key named kid, provided it is within its validity
+// window and the token algorithm is the one of the key. Tokens without kid are checked
+// against the active key.
+func (kr *KeyRing) ResolveKey(kid, alg string) (*Verifier, error) {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	if kid == "" {
+		kid = kr.active
+	}
+	key := kr.find(kid)
+	if key == nil || !key.validAt(kr.now()) || key.Verifier.Method.Alg() != alg {
+		return nil, fmt.Errorf("%w: kid %s alg %s", ErrUnknownKey, kid, alg)
+	}
+	return key.Verifier, nil
+}
+
+// VerificationKeys returns the keys that are valid now or will become valid, so that
+// consumers of the JWKS document know about a new key before it signs tokens.
+func (kr *KeyRing) VerificationKeys() []*Verifier {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	now := kr.now()
+	ret := make([]*Verifier, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		if key.NotAfter.IsZero() || now.Before(key.NotAfter) {
+			ret = append(ret, key.Verifier)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,101 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestECSigner(t *testing.T) *Signer {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := NewSigner(SigningMethodES256, ecKey)
+	assert.NoError(t, err)
+	return signer
+}
+
+func tokenKid(t *testing.T, token string) string {
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	assert.NoError(t, err)
+	values := make(map[string]string)
+	assert.NoError(t, json.Unmarshal(header, &values))
+	return values["kid"]
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	now := time.Now()
+	ring := NewKeyRing()
+	ring.Now = func() time.Time { return now }
+
+	_, err := ring.Signer()
+	assert.ErrorIs(t, err, ErrNoActiveKey)
+
+	assert.NoError(t, ring.Rotate("2024-01", newTestECSigner(t), time.Hour))
+	claim := &GoClaim{Subscriber: "subs@Issuer.com", ExpireAt: now.Add(time.Hour)}
+
+	signer, err := ring.Signer()
+	assert.NoError(t, err)
+	oldToken, err := claim.ToTokenWith(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01", tokenKid(t, oldToken))
+
+	assert.NoError(t, ring.Rotate("2024-02", newTestECSigner(t), time.Hour))
+	signer, err = ring.Signer()
+	assert.NoError(t, err)
+	newToken, err := claim.ToTokenWith(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-02", tokenKid(t, newToken))
+
+	// both keys verify and are published during the overlap
+	_, err = NewGoClaimFromTokenWith(oldToken, ring)
+	assert.NoError(t, err)
+	_, err = NewGoClaimFromTokenWith(newToken, ring)
+	assert.NoError(t, err)
+	assert.Len(t, ring.VerificationKeys(), 2)
+	assert.Len(t, NewJWKSHandler(ring).JWKS().Keys, 2)
+
+	// the previous key retires once the overlap is over
+	now = now.Add(61 * time.Minute)
+	_, err = NewGoClaimFromTokenWith(oldToken, ring)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Len(t, ring.VerificationKeys(), 1)
+
+	ring.Remove("2024-02")
+	_, err = NewGoClaimFromTokenWith(newToken, ring)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = ring.Signer()
+	assert.ErrorIs(t, err, ErrNoActiveKey)
+}
+
+func TestKeyRing_AddAndActivate(t *testing.T) {
+	ring := NewKeyRing()
+	signer := newTestECSigner(t)
+	verifier, err := newTestECSigner(t).Verifier()
+	assert.NoError(t, err)
+
+	assert.NoError(t, ring.Add("future", signer, time.Now().Add(time.Hour), time.Time{}))
+	assert.NoError(t, ring.AddVerifier("foreign", verifier, time.Time{}, time.Time{}))
+	assert.ErrorIs(t, ring.Add("future", signer, time.Time{}, time.Time{}), ErrDuplicateKeyID)
+	assert.ErrorIs(t, ring.Activate("missing"), ErrUnknownKeyID)
+	assert.ErrorIs(t, ring.Activate("foreign"), ErrNoSigner)
+
+	// a key is published before its validity window starts, but does not sign yet
+	assert.NoError(t, ring.Activate("future"))
+	_, err = ring.Signer()
+	assert.ErrorIs(t, err, ErrNoActiveKey)
+	assert.Len(t, ring.VerificationKeys(), 2)
+	_, err = ring.ResolveKey("future", "ES256")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	v, err := ring.ResolveKey("foreign", "ES256")
+	assert.NoError(t, err)
+	assert.Equal(t, "foreign", v.KeyID)
+	_, err = ring.ResolveKey("foreign", "RS256")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
@@ -0,0 +1,136 @@
+package security
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	ErrIssuerNotAllowed   = fmt.Errorf("token issuer is not allowed")
+	ErrAudienceNotAllowed = fmt.Errorf("token audience is not allowed")
+	ErrClaimMissing       = fmt.Errorf("token misses a required claim")
+	ErrTokenTooOld        = fmt.Errorf("token is older than allowed")
+	ErrTokenIssuedLater   = fmt.Errorf("token is issued in the future")
+)
+
+// ValidationPolicy describes the claims a verified token must satisfy. The zero value only
+// checks "exp" and "nbf" when present, exactly like the jose library does.
+type ValidationPolicy struct {
+	// Issuers lists the accepted "iss", any issuer is accepted when empty.
+	Issuers []string
+	// Audience lists the accepted "aud" values, the token must carry at least one of them.
+	// Any audience is accepted when empty.
+	Audience []string
+	// RequiredClaims lists the claims the token must carry, such as "sub", "jti" or "exp".
+	RequiredClaims []string
+	// TokenType is the accepted "typ", such as AccessToken to refuse refresh tokens. Any type is accepted when empty.
+	TokenType TokenType
+	// MaxAge is the maximum time since "iat". When set, "iat" is required and must not be in the future.
+	MaxAge time.Duration
+	// Leeway absorbs the clock skew between the issuer and this service in every time based rule.
+	Leeway time.Duration
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// DefaultValidationPolicy, when set, is the policy NewGoClaimFromToken and
+// NewGoClaimFromTokenWith, and so the UserTokenContextMiddleware, check the claims against.
+var DefaultValidationPolicy *ValidationPolicy
+
+func (p *ValidationPolicy) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// Validate checks the claim against every rule of the policy and returns the first
+// violation as a *TokenError.
+func (p *ValidationPolicy) Validate(gc *GoClaim) error {
+	now := p.now()
+	for _, name := range p.RequiredClaims {
+		if !gc.HasClaim(name) {
+			return newTokenError(ErrClaimMissing, "%s", name)
+		}
+	}
+	// RFC 7519: a token is expired from "exp" on, and is valid from "nbf" on
+	if !gc.ExpireAt.IsZero() && !now.Before(gc.ExpireAt.Add(p.Leeway)) {
+		return newTokenError(ErrTokenExpired, "expired at %s", gc.ExpireAt.Format(time.RFC3339))
+	}
+	if !gc.NotBefore.IsZero() && now.Before(gc.NotBefore.Add(-p.Leeway)) {
+		return newTokenError(ErrTokenNotYetValid, "valid from %s", gc.NotBefore.Format(time.RFC3339))
+	}
+	if p.MaxAge > 0 {
+		if gc.IssuedAt.IsZero() {
+			return newTokenError(ErrClaimMissing, "iat")
+		}
+		if gc.IssuedAt.After(now.Add(p.Leeway)) {
+			return newTokenError(ErrTokenIssuedLater, "issued at %s", gc.IssuedAt.Format(time.RFC3339))
+		}
+		if now.Sub(gc.IssuedAt) > p.MaxAge+p.Leeway {
+			return newTokenError(ErrTokenTooOld, "issued at %s, maximum age %s", gc.IssuedAt.Format(time.RFC3339), p.MaxAge)
+		}
+	}
+	if p.TokenType != "" && gc.TokenType != p.TokenType {
+		return newTokenError(ErrWrongTokenType, "%s", gc.TokenType)
+	}
+	if len(p.Issuers) > 0 && !slices.Contains(p.Issuers, gc.Issuer) {
+		return newTokenError(ErrIssuerNotAllowed, "%s", gc.Issuer)
+	}
+	if len(p.Audience) > 0 {
+		allowed := false
+		for _, aud := range gc.Audience {
+			if slices.Contains(p.Audience, aud) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return newTokenError(ErrAudienceNotAllowed, "%v", gc.Audience)
+		}
+	}
+	return nil
+}
+
+// TokenParser turns token strings into GoClaims. The signature is verified with the key
+// the Resolver finds for the token, then the claims are checked against the Policy, or
+// against the zero ValidationPolicy if Policy is nil, and finally the token is refused if
+// the optional Revocations store denies it. Encrypted tokens are decrypted with the
+// Decrypter first, and are refused when it is nil.
+type TokenParser struct {
+	Resolver    KeyResolver
+	Policy      *ValidationPolicy
+	Revocations RevocationStore
+	Decrypter   *Decrypter
+}
+
+// Parse verifies and validates the token.
+func (tp *TokenParser) Parse(tokenString string) (*GoClaim, error) {
+	if tp.Resolver == nil {
+		return nil, ErrNoVerifier
+	}
+	policy := tp.Policy
+	if policy == nil {
+		policy = &ValidationPolicy{}
+	}
+	if isEncryptedToken(tokenString) {
+		nested, err := decryptNestedToken(tokenString, tp.Decrypter)
+		if err != nil {
+			return nil, err
+		}
+		tokenString = nested
+	}
+	gc, err := parseGoClaim(tokenString, tp.Resolver, policy)
+	if err != nil || tp.Revocations == nil {
+		return gc, err
+	}
+	revoked, err := tp.Revocations.IsRevoked(gc)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, newTokenError(ErrTokenRevoked, "jti %q sub %q", gc.Tokenid, gc.Subscriber)
+	}
+	return gc, nil
+}
@@ -0,0 +1,100 @@
+package security
+
+import (
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestValidationPolicy_Validate(t *testing.T) {
+	now := time.Now()
+	base := func() *GoClaim {
+		return &GoClaim{
+			Issuer:     "https://auth.dokku.me",
+			Subscriber: "subs@Issuer.com",
+			Audience:   []string{"admin@surabaya", "api"},
+			IssuedAt:   now.Add(-10 * time.Minute),
+			NotBefore:  now.Add(-10 * time.Minute),
+			ExpireAt:   now.Add(10 * time.Minute),
+			Tokenid:    "jti-1",
+		}
+	}
+	policy := &ValidationPolicy{
+		Issuers:        []string{"https://auth.dokku.me"},
+		Audience:       []string{"api"},
+		RequiredClaims: []string{"sub", "jti", "exp", "email"},
+		MaxAge:         time.Hour,
+		Leeway:         30 * time.Second,
+		Now:            func() time.Time { return now },
+	}
+
+	gc := base()
+	assert.ErrorIs(t, policy.Validate(gc), ErrClaimMissing)
+	gc.SetClaim("email", "subs@Issuer.com")
+	assert.NoError(t, policy.Validate(gc))
+
+	gc = base()
+	gc.SetClaim("email", "subs@Issuer.com")
+	gc.Tokenid = ""
+	err := policy.Validate(gc)
+	assert.ErrorIs(t, err, ErrClaimMissing)
+	assert.Contains(t, err.Error(), "jti")
+
+	testData := []struct {
+		change func(gc *GoClaim)
+		err    error
+	}{
+		{func(gc *GoClaim) { gc.ExpireAt = now.Add(-20 * time.Second) }, nil},
+		{func(gc *GoClaim) { gc.ExpireAt = now.Add(-time.Minute) }, ErrTokenExpired},
+		{func(gc *GoClaim) { gc.NotBefore = now.Add(20 * time.Second) }, nil},
+		{func(gc *GoClaim) { gc.NotBefore = now.Add(time.Minute) }, ErrTokenNotYetValid},
+		{func(gc *GoClaim) { gc.ExpireAt = now.Add(-30 * time.Second) }, ErrTokenExpired},
+		{func(gc *GoClaim) { gc.ExpireAt = now.Add(-30*time.Second + time.Nanosecond) }, nil},
+		{func(gc *GoClaim) { gc.NotBefore = now.Add(30 * time.Second) }, nil},
+		{func(gc *GoClaim) { gc.NotBefore = now.Add(30*time.Second + time.Nanosecond) }, ErrTokenNotYetValid},
+		{func(gc *GoClaim) { gc.IssuedAt = now.Add(-2 * time.Hour) }, ErrTokenTooOld},
+		{func(gc *GoClaim) { gc.IssuedAt = now.Add(time.Minute) }, ErrTokenIssuedLater},
+		{func(gc *GoClaim) { gc.IssuedAt = time.Time{} }, ErrClaimMissing},
+		{func(gc *GoClaim) { gc.Issuer = "https://evil.example" }, ErrIssuerNotAllowed},
+		{func(gc *GoClaim) { gc.Audience = []string{"admin@surabaya"} }, ErrAudienceNotAllowed},
+	}
+	for i, td := range testData {
+		gc := base()
+		gc.SetClaim("email", "subs@Issuer.com")
+		td.change(gc)
+		if td.err == nil {
+			assert.NoError(t, policy.Validate(gc), "case %d", i)
+		} else {
+			assert.ErrorIs(t, policy.Validate(gc), td.err, "case %d", i)
+		}
+	}
+}
+
+func TestTokenParser_Parse(t *testing.T) {
+	secret := []byte(MakeRandomText(32))
+	signer, err := NewSigner(crypto.SigningMethodHS256, secret)
+	assert.NoError(t, err)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+
+	// as if the issuer clock ran a minute ahead of ours
+	claim := &GoClaim{Issuer: "https://auth.dokku.me", Subscriber: "subs@Issuer.com", NotBefore: time.Now().Add(time.Minute)}
+	token, err := claim.ToTokenWith(signer)
+	assert.NoError(t, err)
+
+	_, err = NewGoClaimFromTokenWith(token, verifier)
+	assert.ErrorIs(t, err, ErrTokenNotYetValid)
+
+	parser := &TokenParser{Resolver: verifier, Policy: &ValidationPolicy{Leeway: 2 * time.Minute}}
+	gc, err := parser.Parse(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "subs@Issuer.com", gc.Subscriber)
+
+	parser.Policy.Issuers = []string{"https://other.dokku.me"}
+	_, err = parser.Parse(token)
+	assert.ErrorIs(t, err, ErrIssuerNotAllowed)
+
+	_, err = (&TokenParser{}).Parse(token)
+	assert.ErrorIs(t, err, ErrNoVerifier)
+}
@@ -0,0 +1,132 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationStore is a denylist of tokens checked by TokenParser after the token is verified.
+type RevocationStore interface {
+	// RevokeToken denies the token with the "jti" until expireAt, its expiration time, after
+	// which the token is refused anyway. A zero expireAt denies the token forever.
+	RevokeToken(tokenID string, expireAt time.Time) error
+	// RevokeSubject denies every token of the subject issued before issuedBefore, including
+	// tokens without "iat", until the time until, which should be at least the expiration of
+	// the longest lived token of the subject.
+	RevokeSubject(subject string, issuedBefore, until time.Time) error
+	// IsRevoked tells whether the token of the claim is denied.
+	IsRevoked(gc *GoClaim) (bool, error)
+}
+
+// DefaultRevocationStore, when set, is consulted by NewGoClaimFromToken and
+// NewGoClaimFromTokenWith, and so by the UserTokenContextMiddleware, to refuse revoked tokens.
+var DefaultRevocationStore RevocationStore
+
+// RevokeGoClaim denies the token of the claim until it expires. It does nothing for tokens
+// without "jti", which can only be revoked with RevokeSubject.
+func RevokeGoClaim(store RevocationStore, gc *GoClaim) error {
+	if gc.Tokenid == "" {
+		return nil
+	}
+	return store.RevokeToken(gc.Tokenid, gc.ExpireAt)
+}
+
+type subjectRevocation struct {
+	issuedBefore time.Time
+	until        time.Time
+}
+
+// InMemoryRevocationStore is a RevocationStore for a single process. Entries are dropped
+// once the tokens they deny have expired. The zero value is ready to use.
+type InMemoryRevocationStore struct {
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	mutex    sync.RWMutex
+	tokens   map[string]time.Time
+	subjects map[string]subjectRevocation
+}
+
+// NewInMemoryRevocationStore creates an empty InMemoryRevocationStore.
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]subjectRevocation),
+	}
+}
+
+func (s *InMemoryRevocationStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func expired(expireAt, now time.Time) bool {
+	return !expireAt.IsZero() && !now.Before(expireAt)
+}
+
+// prune drops the expired entries, the caller must hold the write lock.
+func (s *InMemoryRevocationStore) prune() {
+	now := s.now()
+	for id, expireAt := range s.tokens {
+		if expired(expireAt, now) {
+			delete(s.tokens, id)
+		}
+	}
+	for subject, revocation := range s.subjects {
+		if expired(revocation.until, now) {
+			delete(s.subjects, subject)
+		}
+	}
+}
+
+// RevokeToken implements RevocationStore.
+func (s *InMemoryRevocationStore) RevokeToken(tokenID string, expireAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	if s.tokens == nil {
+		s.tokens = make(map[string]time.Time)
+	}
+	s.tokens[tokenID] = expireAt
+	return nil
+}
+
+// RevokeSubject implements RevocationStore.
+func (s *InMemoryRevocationStore) RevokeSubject(subject string, issuedBefore, until time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	if previous, ok := s.subjects[subject]; ok {
+		if previous.issuedBefore.After(issuedBefore) {
+			issuedBefore = previous.issuedBefore
+		}
+		if previous.until.IsZero() || (!until.IsZero() && previous.until.After(until)) {
+			until = previous.until
+		}
+	}
+	if s.subjects == nil {
+		s.subjects = make(map[string]subjectRevocation)
+	}
+	s.subjects[subject] = subjectRevocation{issuedBefore: issuedBefore, until: until}
+	return nil
+}
+
+// IsRevoked implements RevocationStore.
+func (s *InMemoryRevocationStore) IsRevoked(gc *GoClaim) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	now := s.now()
+	if gc.Tokenid != "" {
+		if expireAt, ok := s.tokens[gc.Tokenid]; ok && !expired(expireAt, now) {
+			return true, nil
+		}
+	}
+	if revocation, ok := s.subjects[gc.Subscriber]; ok && !expired(revocation.until, now) {
+		if gc.IssuedAt.IsZero() || gc.IssuedAt.Before(revocation.issuedBefore) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
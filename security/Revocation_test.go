@@ -0,0 +1,78 @@
+package security
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestInMemoryRevocationStore(t *testing.T) {
+	now := time.Now()
+	store := NewInMemoryRevocationStore()
+	store.Now = func() time.Time { return now }
+
+	leaked := &GoClaim{Subscriber: "subs@Issuer.com", Tokenid: "jti-1", IssuedAt: now, ExpireAt: now.Add(time.Hour)}
+	other := &GoClaim{Subscriber: "subs@Issuer.com", Tokenid: "jti-2", IssuedAt: now, ExpireAt: now.Add(time.Hour)}
+
+	assert.NoError(t, RevokeGoClaim(store, leaked))
+	revoked, err := store.IsRevoked(leaked)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = store.IsRevoked(other)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// the entry goes away with the token expiration
+	now = now.Add(time.Hour)
+	revoked, err = store.IsRevoked(leaked)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	assert.NoError(t, store.RevokeToken("jti-3", now.Add(time.Minute)))
+	assert.Len(t, store.tokens, 1)
+
+	// revoking a subject denies its tokens issued before, not the ones issued after
+	assert.NoError(t, store.RevokeSubject("subs@Issuer.com", now, now.Add(time.Hour)))
+	revoked, err = store.IsRevoked(&GoClaim{Subscriber: "subs@Issuer.com", IssuedAt: now.Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = store.IsRevoked(&GoClaim{Subscriber: "subs@Issuer.com"})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = store.IsRevoked(&GoClaim{Subscriber: "subs@Issuer.com", IssuedAt: now.Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	revoked, err = store.IsRevoked(&GoClaim{Subscriber: "other@Issuer.com", IssuedAt: now.Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestInMemoryRevocationStore_ZeroValue(t *testing.T) {
+	store := &InMemoryRevocationStore{}
+	now := time.Now()
+	assert.NoError(t, store.RevokeToken("jti-1", now.Add(time.Hour)))
+	assert.NoError(t, store.RevokeSubject("subs@Issuer.com", now, now.Add(time.Hour)))
+	revoked, err := store.IsRevoked(&GoClaim{Subscriber: "other@Issuer.com", Tokenid: "jti-1"})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = store.IsRevoked(&GoClaim{Subscriber: "subs@Issuer.com", IssuedAt: now.Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestTokenParser_Revocations(t *testing.T) {
+	signer := newTestECSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	store := NewInMemoryRevocationStore()
+	parser := &TokenParser{Resolver: verifier, Revocations: store}
+
+	claim := &GoClaim{Subscriber: "subs@Issuer.com", Tokenid: "jti-1", IssuedAt: time.Now(), ExpireAt: time.Now().Add(time.Hour)}
+	token, err := claim.ToTokenWith(signer)
+	assert.NoError(t, err)
+
+	gc, err := parser.Parse(token)
+	assert.NoError(t, err)
+	assert.NoError(t, RevokeGoClaim(store, gc))
+	_, err = parser.Parse(token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
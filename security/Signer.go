@@ -0,0 +1,247 @@
+package security
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jws"
+	"math/big"
+)
+
+var (
+	ErrUnsupportedSigningMethod = fmt.Errorf("unsupported signing method")
+	ErrKeyNotSuitable           = fmt.Errorf("key is not suitable for the signing method")
+)
+
+// SigningMethodECDSA implements the ES256, ES384 and ES512 algorithms with the
+// signature encoded as the fixed length R || S pair required by RFC 7518 section 3.4.
+// The ECDSA methods of the jose library encode signatures in ASN.1, which other
+// JWT implementations reject.
+type SigningMethodECDSA struct {
+	Name      string
+	Hash      gocrypto.Hash
+	CurveBits int
+}
+
+// SigningMethodEd25519 implements the EdDSA algorithm of RFC 8037 using Ed25519 keys.
+type SigningMethodEd25519 struct{}
+
+var (
+	SigningMethodES256 = &SigningMethodECDSA{Name: "ES256", Hash: gocrypto.SHA256, CurveBits: 256}
+	SigningMethodES384 = &SigningMethodECDSA{Name: "ES384", Hash: gocrypto.SHA384, CurveBits: 384}
+	SigningMethodES512 = &SigningMethodECDSA{Name: "ES512", Hash: gocrypto.SHA512, CurveBits: 521}
+	SigningMethodEdDSA = &SigningMethodEd25519{}
+)
+
+func init() {
+	// the jose library looks up the method named in a token header before verifying it,
+	// so EdDSA must be known there. ES256/384/512 are already registered under the same names.
+	jws.RegisterSigningMethod(SigningMethodEdDSA)
+}
+
+func (m *SigningMethodECDSA) Alg() string { return m.Name }
+
+func (m *SigningMethodECDSA) Hasher() gocrypto.Hash { return m.Hash }
+
+func (m *SigningMethodECDSA) keyBytes() int {
+	return (m.CurveBits + 7) / 8
+}
+
+func (m *SigningMethodECDSA) sum(raw []byte) []byte {
+	h := m.Hash.New()
+	h.Write(raw)
+	return h.Sum(nil)
+}
+
+// Sign signs raw with an *ecdsa.PrivateKey on the curve matching the method.
+func (m *SigningMethodECDSA) Sign(raw []byte, key interface{}) (crypto.Signature, error) {
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve.Params().BitSize != m.CurveBits {
+		return nil, crypto.ErrInvalidKey
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, m.sum(raw))
+	if err != nil {
+		return nil, err
+	}
+	size := m.keyBytes()
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return crypto.Signature(signature), nil
+}
+
+// Verify verifies signature with an *ecdsa.PublicKey on the curve matching the method.
+func (m *SigningMethodECDSA) Verify(raw []byte, signature crypto.Signature, key interface{}) error {
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || ecKey.Curve.Params().BitSize != m.CurveBits {
+		return crypto.ErrInvalidKey
+	}
+	size := m.keyBytes()
+	if len(signature) != 2*size {
+		return crypto.ErrECDSAVerification
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	if !ecdsa.Verify(ecKey, m.sum(raw), r, s) {
+		return crypto.ErrECDSAVerification
+	}
+	return nil
+}
+
+func (m *SigningMethodEd25519) Alg() string { return "EdDSA" }
+
+// Hasher returns SHA512, which Ed25519 uses internally. The jose library only
+// requires the hash to be linked into the binary.
+func (m *SigningMethodEd25519) Hasher() gocrypto.Hash { return gocrypto.SHA512 }
+
+// Sign signs raw with an ed25519.PrivateKey.
+func (m *SigningMethodEd25519) Sign(raw []byte, key interface{}) (crypto.Signature, error) {
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(edKey) != ed25519.PrivateKeySize {
+		return nil, crypto.ErrInvalidKey
+	}
+	return crypto.Signature(ed25519.Sign(edKey, raw)), nil
+}
+
+// Verify verifies signature with an ed25519.PublicKey.
+func (m *SigningMethodEd25519) Verify(raw []byte, signature crypto.Signature, key interface{}) error {
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(edKey) != ed25519.PublicKeySize {
+		return crypto.ErrInvalidKey
+	}
+	if !ed25519.Verify(edKey, raw, signature) {
+		return crypto.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// SigningMethodByAlg returns the signing method for a JWS "alg" value, or nil if the
+// algorithm is not supported. The unsecured "none" algorithm is never returned.
+func SigningMethodByAlg(alg string) crypto.SigningMethod {
+	switch alg {
+	case SigningMethodES256.Alg():
+		return SigningMethodES256
+	case SigningMethodES384.Alg():
+		return SigningMethodES384
+	case SigningMethodES512.Alg():
+		return SigningMethodES512
+	case SigningMethodEdDSA.Alg():
+		return SigningMethodEdDSA
+	case crypto.Unsecured.Alg():
+		return nil
+	}
+	return jws.GetSigningMethod(alg)
+}
+
+// Signer binds a private (or, for HMAC, shared) key to the method used to sign tokens with it.
+// KeyID, when set, is stamped in the "kid" header of the tokens.
+type Signer struct {
+	KeyID  string
+	Method crypto.SigningMethod
+	Key    interface{}
+}
+
+// Verifier binds a public (or, for HMAC, shared) key to the method its tokens must be signed with.
+// KeyID is the "kid" the key is published under.
+type Verifier struct {
+	KeyID  string
+	Method crypto.SigningMethod
+	Key    interface{}
+}
+
+// SignerSource supplies the signer for new tokens, such as the active key of a KeyRing.
+type SignerSource interface {
+	Signer() (*Signer, error)
+}
+
+// Signer returns the signer itself, so a single Signer can be used as a SignerSource.
+func (s *Signer) Signer() (*Signer, error) {
+	if s == nil {
+		return nil, ErrNoSigner
+	}
+	return s, nil
+}
+
+// NewSigner creates a Signer after making sure the key suits the method:
+// *rsa.PrivateKey for RS* and PS*, *ecdsa.PrivateKey on the right curve for ES*,
+// ed25519.PrivateKey for EdDSA and a []byte secret at least as long as the hash for HS*.
+func NewSigner(method crypto.SigningMethod, key interface{}) (*Signer, error) {
+	if err := checkKey(method, key, true); err != nil {
+		return nil, err
+	}
+	return &Signer{Method: method, Key: key}, nil
+}
+
+// NewVerifier creates a Verifier after making sure the key suits the method:
+// *rsa.PublicKey for RS* and PS*, *ecdsa.PublicKey on the right curve for ES*,
+// ed25519.PublicKey for EdDSA and a []byte secret at least as long as the hash for HS*.
+func NewVerifier(method crypto.SigningMethod, key interface{}) (*Verifier, error) {
+	if err := checkKey(method, key, false); err != nil {
+		return nil, err
+	}
+	return &Verifier{Method: method, Key: key}, nil
+}
+
+// Verifier returns the Verifier able to check the tokens signed by this Signer.
+func (s *Signer) Verifier() (*Verifier, error) {
+	var public interface{}
+	switch key := s.Key.(type) {
+	case *rsa.PrivateKey:
+		public = &key.PublicKey
+	case *ecdsa.PrivateKey:
+		public = &key.PublicKey
+	case ed25519.PrivateKey:
+		public = key.Public()
+	case []byte:
+		public = key
+	default:
+		return nil, ErrKeyNotSuitable
+	}
+	verifier, err := NewVerifier(s.Method, public)
+	if err != nil {
+		return nil, err
+	}
+	verifier.KeyID = s.KeyID
+	return verifier, nil
+}
+
+func checkKey(method crypto.SigningMethod, key interface{}, private bool) error {
+	suitable := false
+	switch m := method.(type) {
+	case *crypto.SigningMethodRSA, *crypto.SigningMethodRSAPSS:
+		if private {
+			_, suitable = key.(*rsa.PrivateKey)
+		} else {
+			_, suitable = key.(*rsa.PublicKey)
+		}
+	case *SigningMethodECDSA:
+		if private {
+			k, ok := key.(*ecdsa.PrivateKey)
+			suitable = ok && k.Curve.Params().BitSize == m.CurveBits
+		} else {
+			k, ok := key.(*ecdsa.PublicKey)
+			suitable = ok && k.Curve.Params().BitSize == m.CurveBits
+		}
+	case *SigningMethodEd25519:
+		if private {
+			k, ok := key.(ed25519.PrivateKey)
+			suitable = ok && len(k) == ed25519.PrivateKeySize
+		} else {
+			k, ok := key.(ed25519.PublicKey)
+			suitable = ok && len(k) == ed25519.PublicKeySize
+		}
+	case *crypto.SigningMethodHMAC:
+		k, ok := key.([]byte)
+		suitable = ok && len(k) >= m.Hash.Size()
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedSigningMethod, method)
+	}
+	if !suitable {
+		return fmt.Errorf("%w: %T for %s", ErrKeyNotSuitable, key, method.Alg())
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner_RoundTrip(t *testing.T) {
+	rsaKey, _, err := GenerateKeyPair(2048)
+	assert.NoError(t, err)
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ec384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	ec521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	secret := []byte(MakeRandomText(64))
+
+	testData := []struct {
+		method  crypto.SigningMethod
+		key     interface{}
+		sigSize int
+	}{
+		{crypto.SigningMethodRS256, rsaKey, 256},
+		{crypto.SigningMethodPS256, rsaKey, 256},
+		{SigningMethodES256, ec256, 64},
+		{SigningMethodES384, ec384, 96},
+		{SigningMethodES512, ec521, 132},
+		{SigningMethodEdDSA, edKey, 64},
+		{crypto.SigningMethodHS256, secret, 32},
+		{crypto.SigningMethodHS512, secret, 64},
+	}
+
+	for _, td := range testData {
+		signer, err := NewSigner(td.method, td.key)
+		assert.NoError(t, err, td.method.Alg())
+		verifier, err := signer.Verifier()
+		assert.NoError(t, err, td.method.Alg())
+
+		claim := &GoClaim{
+			Issuer:     "Issuer.com",
+			Subscriber: "subs@Issuer.com",
+			TokenType:  AccessToken,
+			ExpireAt:   time.Now().Add(time.Hour),
+		}
+		token, err := claim.ToTokenWith(signer)
+		assert.NoError(t, err, td.method.Alg())
+
+		parts := strings.Split(token, ".")
+		assert.Len(t, parts, 3)
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		assert.NoError(t, err)
+		assert.Len(t, sig, td.sigSize, td.method.Alg())
+
+		klaim, err := NewGoClaimFromTokenWith(token, verifier)
+		assert.NoError(t, err, td.method.Alg())
+		assert.Equal(t, claim.Subscriber, klaim.Subscriber)
+	}
+}
+
+func TestSigner_RejectsOtherAlgorithm(t *testing.T) {
+	secret := []byte(MakeRandomText(64))
+	signer, err := NewSigner(crypto.SigningMethodHS256, secret)
+	assert.NoError(t, err)
+	token, err := (&GoClaim{Subscriber: "subs@Issuer.com"}).ToTokenWith(signer)
+	assert.NoError(t, err)
+
+	otherSecret, err := NewVerifier(crypto.SigningMethodHS256, []byte(MakeRandomText(64)))
+	assert.NoError(t, err)
+	_, err = NewGoClaimFromTokenWith(token, otherSecret)
+	assert.Error(t, err)
+
+	otherAlg, err := NewVerifier(crypto.SigningMethodHS512, secret)
+	assert.NoError(t, err)
+	_, err = NewGoClaimFromTokenWith(token, otherAlg)
+	assert.Error(t, err)
+
+	_, err = NewGoClaimFromTokenWith(token, nil)
+	assert.ErrorIs(t, err, ErrNoVerifier)
+}
+
+func TestNewSigner_KeyNotSuitable(t *testing.T) {
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	rsaKey, rsaPub, err := GenerateKeyPair(2048)
+	assert.NoError(t, err)
+
+	_, err = NewSigner(SigningMethodES384, ec256)
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+	_, err = NewSigner(SigningMethodEdDSA, rsaKey)
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+	_, err = NewSigner(crypto.SigningMethodRS256, rsaPub)
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+	_, err = NewVerifier(crypto.SigningMethodRS256, rsaKey)
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+	_, err = NewSigner(crypto.SigningMethodHS256, []byte("short"))
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+	_, err = NewSigner(crypto.Unsecured, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedSigningMethod)
+}
+
+func TestSigningMethodByAlg(t *testing.T) {
+	assert.Equal(t, SigningMethodES256, SigningMethodByAlg("ES256"))
+	assert.Equal(t, SigningMethodEdDSA, SigningMethodByAlg("EdDSA"))
+	assert.Equal(t, crypto.SigningMethodRS512, SigningMethodByAlg("RS512"))
+	assert.Nil(t, SigningMethodByAlg("none"))
+	assert.Nil(t, SigningMethodByAlg("XX999"))
+}
@@ -2,6 +2,7 @@ package security
 
 import (
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"strings"
 )
 
@@ -36,12 +37,11 @@ type TenantRole struct {
 }
 
 func (tr *TenantRole) Validates(tenant, role string) bool {
-	fmt.Println("stage one tenant", tenant, "role", role)
 	tenandValid := tr.tenantValid(tenant)
 	roleValid := tr.roleValid(role)
 	ret := tenandValid && roleValid
 	if !ret {
-		fmt.Printf("Tenant = %v && Role = %v FOR %s $ %s\n", tenandValid, roleValid, role, tenant)
+		logrus.Debugf("tenant-role refused role %s in tenant %s, tenant valid %v, role valid %v", role, tenant, tenandValid, roleValid)
 	}
 	return ret
 }
@@ -69,3 +69,13 @@ func (tr *TenantRole) roleValid(role string) bool {
 	}
 	return false
 }
+
+// Tenants returns the tenant IDs of the tenant-role, "*" standing for any tenant.
+func (tr *TenantRole) Tenants() []string {
+	return append([]string(nil), tr.tenantIDs...)
+}
+
+// Roles returns the role IDs of the tenant-role, "*" standing for any role.
+func (tr *TenantRole) Roles() []string {
+	return append([]string(nil), tr.roleIDs...)
+}
@@ -24,3 +24,13 @@ func TestTenantRole_Validates(t *testing.T) {
 	assert.False(t, tr.Validates("makasar", "notenant"))
 	assert.False(t, tr.Validates("makasar", ""))
 }
+
+func TestTenantRole_TenantsAndRolesAreCopies(t *testing.T) {
+	tr, err := NewTenantRole("user@surabaya")
+	assert.NoError(t, err)
+	tr.Roles()[0] = "admin"
+	tr.Tenants()[0] = "*"
+	assert.Equal(t, []string{"user"}, tr.Roles())
+	assert.Equal(t, []string{"surabaya"}, tr.Tenants())
+	assert.False(t, tr.Validates("padang", "admin"))
+}
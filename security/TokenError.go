@@ -0,0 +1,52 @@
+package security
+
+import (
+	"fmt"
+)
+
+// Errors returned, wrapped in a *TokenError, when a token is refused. Test them with errors.Is.
+// ErrTokenExpired means the client should refresh its token, the others that the token is
+// not usable at all.
+var (
+	ErrMalformed          = fmt.Errorf("malformed jwt token")
+	ErrSignatureInvalid   = fmt.Errorf("token signature is invalid")
+	ErrUnknownKey         = fmt.Errorf("no key found to verify the token")
+	ErrTokenExpired       = fmt.Errorf("token is expired")
+	ErrTokenNotYetValid   = fmt.Errorf("token is not yet valid")
+	ErrWrongTokenType     = fmt.Errorf("token is of the wrong type")
+	ErrTokenRevoked       = fmt.Errorf("token is revoked")
+	ErrTokenInactive      = fmt.Errorf("token is not active")
+	ErrTokenUndecryptable = fmt.Errorf("token can not be decrypted")
+)
+
+// TokenError is the error returned for a refused token. Err is one of the sentinel errors
+// of this package, such as ErrTokenExpired or ErrIssuerNotAllowed, Detail tells what exactly
+// failed, and Cause is the underlying error of the jose library, if any.
+type TokenError struct {
+	Err    error
+	Detail string
+	Cause  error
+}
+
+func (e *TokenError) Error() string {
+	msg := e.Err.Error()
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Detail)
+	}
+	if e.Cause != nil {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Cause.Error())
+	}
+	return msg
+}
+
+// Unwrap makes both the sentinel and the cause visible to errors.Is and errors.As.
+func (e *TokenError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
+func newTokenError(sentinel error, format string, args ...interface{}) *TokenError {
+	return &TokenError{Err: sentinel, Detail: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,60 @@
+package security
+
+import (
+	"errors"
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTokenError_Classification(t *testing.T) {
+	secret := []byte(MakeRandomText(32))
+	signer, err := NewSigner(crypto.SigningMethodHS256, secret)
+	assert.NoError(t, err)
+	signer.KeyID = "hs"
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	otherVerifier, err := NewVerifier(crypto.SigningMethodHS256, []byte(MakeRandomText(32)))
+	assert.NoError(t, err)
+	otherVerifier.KeyID = "hs"
+
+	sign := func(gc *GoClaim) string {
+		token, err := gc.ToTokenWith(signer)
+		assert.NoError(t, err)
+		return token
+	}
+	valid := sign(&GoClaim{Subscriber: "subs@Issuer.com", TokenType: AccessToken, ExpireAt: time.Now().Add(time.Hour)})
+	expired := sign(&GoClaim{Subscriber: "subs@Issuer.com", ExpireAt: time.Now().Add(-time.Hour)})
+	early := sign(&GoClaim{Subscriber: "subs@Issuer.com", NotBefore: time.Now().Add(time.Hour)})
+	refresh := sign(&GoClaim{Subscriber: "subs@Issuer.com", TokenType: RefreshToken})
+
+	accessOnly := &ValidationPolicy{TokenType: AccessToken}
+	testData := []struct {
+		token    string
+		resolver KeyResolver
+		err      error
+	}{
+		{"not.a.token", verifier, ErrMalformed},
+		{valid, otherVerifier, ErrSignatureInvalid},
+		{valid, StaticKeys{}, ErrUnknownKey},
+		{expired, verifier, ErrTokenExpired},
+		{early, verifier, ErrTokenNotYetValid},
+		{refresh, verifier, ErrWrongTokenType},
+	}
+	for _, td := range testData {
+		_, err := (&TokenParser{Resolver: td.resolver, Policy: accessOnly}).Parse(td.token)
+		assert.ErrorIs(t, err, td.err)
+
+		tokenError := &TokenError{}
+		assert.True(t, errors.As(err, &tokenError))
+		assert.Equal(t, td.err, tokenError.Err)
+	}
+
+	_, err = (&TokenParser{Resolver: otherVerifier}).Parse(valid)
+	assert.ErrorIs(t, err, crypto.ErrSignatureInvalid)
+	assert.Contains(t, err.Error(), ErrSignatureInvalid.Error())
+
+	_, err = (&TokenParser{Resolver: verifier, Policy: accessOnly}).Parse(valid)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,269 @@
+package security
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRefreshTokenNotFound = fmt.Errorf("refresh token is unknown or revoked")
+	ErrRefreshTokenReused   = fmt.Errorf("refresh token was already redeemed, its token family is revoked")
+)
+
+// TokenPair is an access token with the refresh token to obtain the next pair.
+type TokenPair struct {
+	AccessToken     string
+	RefreshToken    string
+	AccessExpireAt  time.Time
+	RefreshExpireAt time.Time
+}
+
+// RefreshTokenRecord is what a RefreshTokenStore keeps about an issued refresh token.
+// Every refresh token redeemed from another belongs to the same family.
+type RefreshTokenRecord struct {
+	TokenID  string
+	FamilyID string
+	Subject  string
+	Audience []string
+	ExpireAt time.Time
+	Used     bool
+}
+
+// RefreshTokenStore keeps the refresh tokens issued by a TokenIssuer.
+type RefreshTokenStore interface {
+	// Save stores the record of a newly issued refresh token.
+	Save(record *RefreshTokenRecord) error
+	// Use marks the refresh token as used and returns its record as it was before, so a
+	// record with Used set means the token is being replayed. It must be atomic, and must
+	// return ErrRefreshTokenNotFound for unknown, expired or revoked tokens.
+	Use(tokenID string) (*RefreshTokenRecord, error)
+	// RevokeFamily forgets every refresh token of the family.
+	RevokeFamily(familyID string) error
+}
+
+// TokenIssuer mints access and refresh token pairs and redeems refresh tokens for new pairs.
+// A refresh token can be redeemed once. Redeeming it again means it leaked, so the whole
+// family of tokens derived from the same login is revoked. Refresh tokens denied by
+// Revocations, DefaultRevocationStore when nil, can not be redeemed either.
+type TokenIssuer struct {
+	Issuer          string
+	Signer          SignerSource
+	Resolver        KeyResolver
+	Store           RefreshTokenStore
+	Revocations     RevocationStore
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewTokenIssuer creates a TokenIssuer issuing 15 minutes access tokens and 7 days refresh
+// tokens. The resolver verifies redeemed refresh tokens, it is the Verifier of a single
+// Signer or the KeyRing itself.
+func NewTokenIssuer(issuer string, signer SignerSource, resolver KeyResolver, store RefreshTokenStore) *TokenIssuer {
+	return &TokenIssuer{
+		Issuer:          issuer,
+		Signer:          signer,
+		Resolver:        resolver,
+		Store:           store,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 7 * 24 * time.Hour,
+	}
+}
+
+func (ti *TokenIssuer) now() time.Time {
+	if ti.Now != nil {
+		return ti.Now()
+	}
+	return time.Now()
+}
+
+func (ti *TokenIssuer) revocations() RevocationStore {
+	if ti.Revocations != nil {
+		return ti.Revocations
+	}
+	return DefaultRevocationStore
+}
+
+// Issue mints a new pair, starting a new token family, for the subject and audience.
+func (ti *TokenIssuer) Issue(subject string, audience []string) (*TokenPair, error) {
+	familyID, err := NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	return ti.issue(familyID, subject, audience)
+}
+
+// Redeem exchanges a refresh token for a new pair of the same family. The refresh token
+// can not be redeemed again.
+func (ti *TokenIssuer) Redeem(refreshToken string) (*TokenPair, error) {
+	parser := &TokenParser{
+		Resolver: ti.Resolver,
+		Policy: &ValidationPolicy{
+			Issuers:        []string{ti.Issuer},
+			RequiredClaims: []string{"sub", "jti", "exp"},
+			TokenType:      RefreshToken,
+			Now:            ti.Now,
+		},
+		Revocations: ti.revocations(),
+	}
+	gc, err := parser.Parse(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	record, err := ti.Store.Use(gc.Tokenid)
+	if err != nil {
+		return nil, err
+	}
+	if record.Used {
+		if err := ti.Store.RevokeFamily(record.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: subject %s", ErrRefreshTokenReused, record.Subject)
+	}
+	return ti.issue(record.FamilyID, record.Subject, record.Audience)
+}
+
+func (ti *TokenIssuer) issue(familyID, subject string, audience []string) (*TokenPair, error) {
+	signer, err := ti.Signer.Signer()
+	if err != nil {
+		return nil, err
+	}
+	now := ti.now()
+	pair := &TokenPair{
+		AccessExpireAt:  now.Add(ti.AccessTokenTTL),
+		RefreshExpireAt: now.Add(ti.RefreshTokenTTL),
+	}
+
+	accessID, err := NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	access := &GoClaim{
+		Issuer:     ti.Issuer,
+		Subscriber: subject,
+		TokenType:  AccessToken,
+		Audience:   audience,
+		NotBefore:  now,
+		IssuedAt:   now,
+		ExpireAt:   pair.AccessExpireAt,
+		Tokenid:    accessID,
+	}
+	if pair.AccessToken, err = access.ToTokenWith(signer); err != nil {
+		return nil, err
+	}
+
+	refreshID, err := NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	refresh := &GoClaim{
+		Issuer:     ti.Issuer,
+		Subscriber: subject,
+		TokenType:  RefreshToken,
+		Audience:   audience,
+		NotBefore:  now,
+		IssuedAt:   now,
+		ExpireAt:   pair.RefreshExpireAt,
+		Tokenid:    refreshID,
+	}
+	if pair.RefreshToken, err = refresh.ToTokenWith(signer); err != nil {
+		return nil, err
+	}
+
+	err = ti.Store.Save(&RefreshTokenRecord{
+		TokenID:  refreshID,
+		FamilyID: familyID,
+		Subject:  subject,
+		Audience: audience,
+		ExpireAt: pair.RefreshExpireAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// NewTokenID returns a random identifier suitable for the "jti" claim.
+func NewTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// InMemoryRefreshTokenStore is a RefreshTokenStore for a single process. Expired records
+// are dropped when they are used, and at most once a minute as new ones are saved.
+type InMemoryRefreshTokenStore struct {
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	mutex    sync.Mutex
+	records  map[string]*RefreshTokenRecord
+	prunedAt time.Time
+}
+
+// NewInMemoryRefreshTokenStore creates an empty InMemoryRefreshTokenStore.
+func NewInMemoryRefreshTokenStore() *InMemoryRefreshTokenStore {
+	return &InMemoryRefreshTokenStore{records: make(map[string]*RefreshTokenRecord)}
+}
+
+func (s *InMemoryRefreshTokenStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Save implements RefreshTokenStore.
+func (s *InMemoryRefreshTokenStore) Save(record *RefreshTokenRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.records == nil {
+		s.records = make(map[string]*RefreshTokenRecord)
+	}
+	if now := s.now(); now.Sub(s.prunedAt) > time.Minute {
+		for id, rec := range s.records {
+			if !now.Before(rec.ExpireAt) {
+				delete(s.records, id)
+			}
+		}
+		s.prunedAt = now
+	}
+	saved := *record
+	s.records[record.TokenID] = &saved
+	return nil
+}
+
+// Use implements RefreshTokenStore.
+func (s *InMemoryRefreshTokenStore) Use(tokenID string) (*RefreshTokenRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rec, ok := s.records[tokenID]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if !s.now().Before(rec.ExpireAt) {
+		delete(s.records, tokenID)
+		return nil, ErrRefreshTokenNotFound
+	}
+	before := *rec
+	rec.Used = true
+	return &before, nil
+}
+
+// RevokeFamily implements RefreshTokenStore.
+func (s *InMemoryRefreshTokenStore) RevokeFamily(familyID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, rec := range s.records {
+		if rec.FamilyID == familyID {
+			delete(s.records, id)
+		}
+	}
+	return nil
+}
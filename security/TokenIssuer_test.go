@@ -0,0 +1,100 @@
+package security
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTokenIssuer_IssueAndRedeem(t *testing.T) {
+	ring := NewKeyRing()
+	assert.NoError(t, ring.Rotate("key-1", newTestECSigner(t), time.Hour))
+	issuer := NewTokenIssuer("https://auth.dokku.me", ring, ring, NewInMemoryRefreshTokenStore())
+
+	pair, err := issuer.Issue("subs@Issuer.com", []string{"admin@surabaya"})
+	assert.NoError(t, err)
+
+	access, err := NewGoClaimFromTokenWith(pair.AccessToken, ring)
+	assert.NoError(t, err)
+	assert.Equal(t, "subs@Issuer.com", access.Subscriber)
+	assert.Equal(t, []string{"admin@surabaya"}, access.Audience)
+	assert.Equal(t, AccessToken, access.TokenType)
+	assert.NotEmpty(t, access.Tokenid)
+	assert.Equal(t, pair.AccessExpireAt.Unix(), access.ExpireAt.Unix())
+
+	// an access token can not be redeemed
+	_, err = issuer.Redeem(pair.AccessToken)
+	assert.ErrorIs(t, err, ErrWrongTokenType)
+
+	next, err := issuer.Redeem(pair.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, pair.RefreshToken, next.RefreshToken)
+	refreshed, err := NewGoClaimFromTokenWith(next.AccessToken, ring)
+	assert.NoError(t, err)
+	assert.Equal(t, "subs@Issuer.com", refreshed.Subscriber)
+	assert.Equal(t, []string{"admin@surabaya"}, refreshed.Audience)
+
+	// a new login is a separate family
+	other, err := issuer.Issue("subs@Issuer.com", []string{"admin@surabaya"})
+	assert.NoError(t, err)
+
+	// replaying the redeemed refresh token revokes its family
+	_, err = issuer.Redeem(pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	_, err = issuer.Redeem(next.RefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenNotFound)
+
+	_, err = issuer.Redeem(other.RefreshToken)
+	assert.NoError(t, err)
+}
+
+func TestTokenIssuer_RedeemExpired(t *testing.T) {
+	now := time.Now()
+	signer := newTestECSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	store := NewInMemoryRefreshTokenStore()
+	issuer := NewTokenIssuer("https://auth.dokku.me", signer, verifier, store)
+	issuer.Now = func() time.Time { return now }
+	store.Now = issuer.Now
+
+	pair, err := issuer.Issue("subs@Issuer.com", nil)
+	assert.NoError(t, err)
+
+	now = now.Add(8 * 24 * time.Hour)
+	_, err = issuer.Redeem(pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	_, err = store.Use("unknown")
+	assert.ErrorIs(t, err, ErrRefreshTokenNotFound)
+}
+
+func TestTokenIssuer_RedeemAtIssuance(t *testing.T) {
+	// a whole second clock, so "nbf" is exactly the time of the redemption
+	now := time.Now().Truncate(time.Second)
+	signer := newTestECSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	issuer := NewTokenIssuer("https://auth.dokku.me", signer, verifier, NewInMemoryRefreshTokenStore())
+	issuer.Now = func() time.Time { return now }
+
+	pair, err := issuer.Issue("subs@Issuer.com", nil)
+	assert.NoError(t, err)
+	_, err = issuer.Redeem(pair.RefreshToken)
+	assert.NoError(t, err)
+}
+
+func TestTokenIssuer_RedeemRevoked(t *testing.T) {
+	signer := newTestECSigner(t)
+	verifier, err := signer.Verifier()
+	assert.NoError(t, err)
+	revocations := NewInMemoryRevocationStore()
+	issuer := NewTokenIssuer("https://auth.dokku.me", signer, verifier, &InMemoryRefreshTokenStore{})
+	issuer.Revocations = revocations
+
+	pair, err := issuer.Issue("subs@Issuer.com", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, revocations.RevokeSubject("subs@Issuer.com", time.Now().Add(time.Second), time.Time{}))
+	_, err = issuer.Redeem(pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
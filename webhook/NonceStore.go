@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceStore remembers the nonces of the webhooks already delivered, so a captured webhook
+// can not be replayed while its timestamp is still within the tolerance.
+type NonceStore interface {
+	// Seen records the nonce until expireAt and tells whether it was already recorded.
+	Seen(nonce string, expireAt time.Time) (bool, error)
+}
+
+// InMemoryNonceStore is a NonceStore for a single process. Nonces are dropped once they
+// expire, after which their webhook is refused for its timestamp anyway. The zero value is
+// ready to use.
+type InMemoryNonceStore struct {
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	mutex    sync.Mutex
+	nonces   map[string]time.Time
+	prunedAt time.Time
+}
+
+// NewInMemoryNonceStore creates an empty InMemoryNonceStore.
+func NewInMemoryNonceStore() *InMemoryNonceStore {
+	return &InMemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *InMemoryNonceStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Seen implements NonceStore.
+func (s *InMemoryNonceStore) Seen(nonce string, expireAt time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if now.Sub(s.prunedAt) > time.Minute {
+		for n, until := range s.nonces {
+			if !now.Before(until) {
+				delete(s.nonces, n)
+			}
+		}
+		s.prunedAt = now
+	}
+	if until, ok := s.nonces[nonce]; ok && now.Before(until) {
+		return true, nil
+	}
+	if s.nonces == nil {
+		s.nonces = make(map[string]time.Time)
+	}
+	s.nonces[nonce] = expireAt
+	return false, nil
+}
@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestInMemoryNonceStore(t *testing.T) {
+	now := time.Now()
+	store := NewInMemoryNonceStore()
+	store.Now = func() time.Time { return now }
+
+	seen, err := store.Seen("n1", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, seen)
+	seen, _ = store.Seen("n1", now.Add(time.Minute))
+	assert.True(t, seen)
+	seen, _ = store.Seen("n2", now.Add(time.Minute))
+	assert.False(t, seen)
+
+	now = now.Add(2 * time.Minute)
+	seen, _ = store.Seen("n1", now.Add(time.Minute))
+	assert.False(t, seen)
+	assert.Len(t, store.nonces, 1)
+}
+
+func TestInMemoryNonceStore_ZeroValue(t *testing.T) {
+	store := &InMemoryNonceStore{}
+	seen, err := store.Seen("n1", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, seen)
+	seen, _ = store.Seen("n1", time.Now().Add(time.Minute))
+	assert.True(t, seen)
+}
@@ -0,0 +1,308 @@
+// Package webhook signs the webhooks Dokku apps send each other and verifies the ones
+// they receive, with a shared HMAC-SHA256 secret or an RSA-PSS key pair.
+package webhook
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	dokku_common "github.com/newm4n/dokku-common"
+	"github.com/newm4n/dokku-common/security"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header carrying the signature of a webhook, such as
+// "X-Signature: t=1700000000,n=6f1c...,kid=2024-01,alg=hmac-sha256,sig=...".
+// The signature covers the timestamp, the nonce and the body, joined with dots.
+const SignatureHeader = "X-Signature"
+
+const (
+	AlgHMACSHA256   = "hmac-sha256"
+	AlgRSAPSSSHA256 = "rsa-pss-sha256"
+)
+
+var (
+	ErrSignatureMissing    = fmt.Errorf("webhook is not signed")
+	ErrSignatureMalformed  = fmt.Errorf("malformed webhook signature")
+	ErrSignatureInvalid    = fmt.Errorf("invalid webhook signature")
+	ErrTimestampOutOfRange = fmt.Errorf("webhook timestamp is out of the tolerance")
+	ErrReplayed            = fmt.Errorf("webhook was already delivered")
+	ErrKeyNotSuitable      = fmt.Errorf("webhook key can not do that")
+)
+
+// Key is a webhook key named by ID, either an HMAC-SHA256 Secret shared by the sender and
+// the receiver, or an RSA key pair whose PrivateKey signs and PublicKey verifies.
+type Key struct {
+	ID         string
+	Secret     []byte
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+}
+
+// HMACKey creates the key of a shared secret.
+func HMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Secret: secret}
+}
+
+// RSAKey creates the key of a sender signing with the private key.
+func RSAKey(id string, privateKey *rsa.PrivateKey) *Key {
+	return &Key{ID: id, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+}
+
+// RSAPublicKey creates the key of a receiver verifying with the public key.
+func RSAPublicKey(id string, publicKey *rsa.PublicKey) *Key {
+	return &Key{ID: id, PublicKey: publicKey}
+}
+
+// Algorithm returns the signature algorithm of the key.
+func (k *Key) Algorithm() string {
+	if len(k.Secret) > 0 {
+		return AlgHMACSHA256
+	}
+	return AlgRSAPSSSHA256
+}
+
+func (k *Key) sign(payload []byte) ([]byte, error) {
+	if len(k.Secret) > 0 {
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(payload)
+		return mac.Sum(nil), nil
+	}
+	if k.PrivateKey == nil {
+		return nil, fmt.Errorf("%w: no private key to sign with", ErrKeyNotSuitable)
+	}
+	return security.SignWithPrivateKeyPSS(payload, k.PrivateKey)
+}
+
+func (k *Key) verify(payload, signature []byte) bool {
+	if len(k.Secret) > 0 {
+		expected, _ := k.sign(payload)
+		return hmac.Equal(expected, signature)
+	}
+	if k.PublicKey == nil {
+		return false
+	}
+	digest := sha256.Sum256(payload)
+	return rsa.VerifyPSS(k.PublicKey, crypto.SHA256, digest[:], signature, nil) == nil
+}
+
+func signedPayload(timestamp int64, nonce string, body []byte) []byte {
+	payload := make([]byte, 0, len(body)+40)
+	payload = strconv.AppendInt(payload, timestamp, 10)
+	payload = append(payload, '.')
+	payload = append(payload, nonce...)
+	payload = append(payload, '.')
+	return append(payload, body...)
+}
+
+// Signer signs outgoing webhooks with its Key.
+type Signer struct {
+	Key *Key
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewSigner creates a Signer signing with the key.
+func NewSigner(key *Key) *Signer {
+	return &Signer{Key: key}
+}
+
+func (s *Signer) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Sign returns the SignatureHeader value of the body, with the current time and a random nonce.
+func (s *Signer) Sign(body []byte) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	timestamp, nonce := s.now().Unix(), hex.EncodeToString(random)
+	signature, err := s.Key.sign(signedPayload(timestamp, nonce, body))
+	if err != nil {
+		return "", err
+	}
+	fields := []string{"t=" + strconv.FormatInt(timestamp, 10), "n=" + nonce}
+	if s.Key.ID != "" {
+		fields = append(fields, "kid="+s.Key.ID)
+	}
+	fields = append(fields, "alg="+s.Key.Algorithm(), "sig="+base64.StdEncoding.EncodeToString(signature))
+	return strings.Join(fields, ","), nil
+}
+
+// SignRequest signs the body of the request and sets its SignatureHeader. The body is read
+// and replaced by a copy.
+func (s *Signer) SignRequest(r *http.Request) error {
+	body := []byte{}
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	header, err := s.Sign(body)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(SignatureHeader, header)
+	return nil
+}
+
+// Verifier checks incoming webhooks against its Keys, several of them while a secret or key
+// pair is being rotated. A signature naming a key ID is only checked with the keys of that
+// ID. Timestamps must be within Tolerance of the current time, and nonces must not have been
+// seen by the Nonces store, when set, to refuse replays. Tolerance is five minutes when zero.
+type Verifier struct {
+	Keys      []*Key
+	Tolerance time.Duration
+	Nonces    NonceStore
+	// MaxBodySize limits the body Middleware reads, 1 MiB when zero.
+	MaxBodySize int64
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewVerifier creates a Verifier accepting the keys, with a five minutes tolerance and an
+// InMemoryNonceStore.
+func NewVerifier(keys ...*Key) *Verifier {
+	return &Verifier{Keys: keys, Tolerance: 5 * time.Minute, Nonces: NewInMemoryNonceStore()}
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+func (v *Verifier) tolerance() time.Duration {
+	if v.Tolerance > 0 {
+		return v.Tolerance
+	}
+	return 5 * time.Minute
+}
+
+// Verify checks the SignatureHeader value of the body.
+func (v *Verifier) Verify(header string, body []byte) error {
+	if strings.TrimSpace(header) == "" {
+		return ErrSignatureMissing
+	}
+	fields := make(map[string]string, 5)
+	for _, field := range strings.Split(header, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return fmt.Errorf("%w: %q", ErrSignatureMalformed, field)
+		}
+		fields[name] = value
+	}
+	timestamp, err := strconv.ParseInt(fields["t"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: no timestamp", ErrSignatureMalformed)
+	}
+	nonce, alg := fields["n"], fields["alg"]
+	if nonce == "" || alg == "" {
+		return fmt.Errorf("%w: no nonce or algorithm", ErrSignatureMalformed)
+	}
+	// a dot in the nonce would let a replay move the start of the body
+	if strings.ContainsRune(nonce, '.') {
+		return fmt.Errorf("%w: invalid nonce", ErrSignatureMalformed)
+	}
+	signature, err := base64.StdEncoding.DecodeString(fields["sig"])
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: no signature", ErrSignatureMalformed)
+	}
+
+	sentAt := time.Unix(timestamp, 0)
+	tolerance := v.tolerance()
+	if skew := v.now().Sub(sentAt); skew > tolerance || skew < -tolerance {
+		return fmt.Errorf("%w: sent %s ago", ErrTimestampOutOfRange, skew.Truncate(time.Second))
+	}
+
+	payload, kid, verified := signedPayload(timestamp, nonce, body), fields["kid"], false
+	for _, key := range v.Keys {
+		if key.Algorithm() != alg || (kid != "" && key.ID != kid) {
+			continue
+		}
+		if key.verify(payload, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return fmt.Errorf("%w: no %s key %s matches", ErrSignatureInvalid, alg, kid)
+	}
+
+	if v.Nonces != nil {
+		seen, err := v.Nonces.Seen(nonce, sentAt.Add(tolerance))
+		if err != nil {
+			return err
+		}
+		if seen {
+			return fmt.Errorf("%w: nonce %s", ErrReplayed, nonce)
+		}
+	}
+	return nil
+}
+
+// Middleware verifies the webhooks before handing them to next, with their body restored.
+// Webhooks that fail verification are refused with 401, those larger than MaxBodySize with
+// 413, both with problem details.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBodySize := v.MaxBodySize
+		if maxBodySize <= 0 {
+			maxBodySize = 1 << 20
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			status := http.StatusBadRequest
+			if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			dokku_common.WriteProblem(w, dokku_common.NewProblem(status, err.Error()))
+			return
+		}
+		if err := v.Verify(r.Header.Get(SignatureHeader), body); err != nil {
+			if !isVerificationError(err) {
+				// the nonce store failed, the webhook may be legitimate
+				logrus.Errorf("webhook verification of %s failed: %s", r.URL.Path, err.Error())
+				dokku_common.WriteProblem(w, dokku_common.NewProblem(http.StatusInternalServerError, "webhook verification failed"))
+				return
+			}
+			dokku_common.WriteProblem(w, dokku_common.NewProblem(http.StatusUnauthorized, err.Error()))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isVerificationError(err error) bool {
+	for _, e := range []error{ErrSignatureMissing, ErrSignatureMalformed, ErrSignatureInvalid, ErrTimestampOutOfRange, ErrReplayed} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifier_HMAC(t *testing.T) {
+	now := time.Now()
+	oldKey, newKey := HMACKey("2023", []byte("old secret")), HMACKey("2024", []byte("new secret"))
+	verifier := NewVerifier(oldKey, newKey)
+	verifier.Now = func() time.Time { return now }
+	body := []byte(`{"event":"deployed","app":"web"}`)
+
+	// both secrets are accepted during the rotation
+	for _, key := range []*Key{oldKey, newKey, HMACKey("", []byte("new secret"))} {
+		header, err := NewSigner(key).Sign(body)
+		assert.NoError(t, err)
+		assert.NoError(t, verifier.Verify(header, body))
+		assert.ErrorIs(t, verifier.Verify(header, body), ErrReplayed)
+	}
+
+	sign := func(key *Key, sentAt time.Time) string {
+		header, err := (&Signer{Key: key, Now: func() time.Time { return sentAt }}).Sign(body)
+		assert.NoError(t, err)
+		return header
+	}
+	testData := []struct {
+		header string
+		body   string
+		err    error
+	}{
+		{"", string(body), ErrSignatureMissing},
+		{"t=1,sig", string(body), ErrSignatureMalformed},
+		{sign(newKey, now), `{"event":"deleted","app":"web"}`, ErrSignatureInvalid},
+		{sign(HMACKey("2024", []byte("guessed")), now), string(body), ErrSignatureInvalid},
+		{sign(HMACKey("2022", []byte("old secret")), now), string(body), ErrSignatureInvalid},
+		{sign(newKey, now.Add(-10*time.Minute)), string(body), ErrTimestampOutOfRange},
+		{sign(newKey, now.Add(10*time.Minute)), string(body), ErrTimestampOutOfRange},
+		{strings.Replace(sign(newKey, now), "n=", "n=a.", 1), string(body), ErrSignatureMalformed},
+	}
+	for _, td := range testData {
+		assert.ErrorIs(t, verifier.Verify(td.header, []byte(td.body)), td.err, td.header)
+	}
+
+	// a Verifier without tolerance uses the five minutes default
+	zero := &Verifier{Keys: []*Key{newKey}, Now: verifier.Now}
+	assert.NoError(t, zero.Verify(sign(newKey, now.Add(-time.Minute)), body))
+	assert.ErrorIs(t, zero.Verify(sign(newKey, now.Add(-10*time.Minute)), body), ErrTimestampOutOfRange)
+}
+
+func TestVerifier_RSA(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	verifier := NewVerifier(RSAPublicKey("sender", &privateKey.PublicKey), HMACKey("sender", []byte("secret")))
+	body := []byte("payload")
+
+	header, err := NewSigner(RSAKey("sender", privateKey)).Sign(body)
+	assert.NoError(t, err)
+	assert.Contains(t, header, "alg="+AlgRSAPSSSHA256)
+	assert.NoError(t, verifier.Verify(header, body))
+
+	header, err = NewSigner(RSAKey("sender", otherKey)).Sign(body)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, verifier.Verify(header, body), ErrSignatureInvalid)
+
+	_, err = NewSigner(RSAPublicKey("sender", &privateKey.PublicKey)).Sign(body)
+	assert.ErrorIs(t, err, ErrKeyNotSuitable)
+}
+
+func TestVerifier_Middleware(t *testing.T) {
+	key := HMACKey("k1", []byte("secret"))
+	verifier := NewVerifier(key)
+	verifier.MaxBodySize = 64
+	var received string
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(body string, sign bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/hooks/deploy", strings.NewReader(body))
+		if sign {
+			assert.NoError(t, NewSigner(key).SignRequest(req))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusNoContent, serve(`{"app":"web"}`, true))
+	assert.Equal(t, `{"app":"web"}`, received)
+	assert.Equal(t, http.StatusUnauthorized, serve(`{"app":"web"}`, false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(strings.Repeat("x", 65), true))
+}